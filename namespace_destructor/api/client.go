@@ -0,0 +1,252 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"namespace_destructor/metrics"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 3
+	defaultRetryDelay = 500 * time.Millisecond
+)
+
+// Authenticator adiciona as credenciais a uma requisição antes do envio.
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// AuthFunc permite usar uma função simples como Authenticator.
+type AuthFunc func(req *http.Request) error
+
+func (f AuthFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+// BearerToken autentica as requisições com o header `Authorization: Bearer <token>`.
+func BearerToken(token string) Authenticator {
+	return AuthFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// HeaderKey autentica as requisições com uma chave em um header, ex: `X-Api-Key`.
+func HeaderKey(header, key string) Authenticator {
+	return AuthFunc(func(req *http.Request) error {
+		req.Header.Set(header, key)
+		return nil
+	})
+}
+
+// HTTPError é retornado quando a API responde com um status diferente de 2xx.
+// O corpo da resposta é mantido para facilitar o diagnóstico (ex: uma página de erro em HTML).
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *HTTPError) Error() string {
+	body := strings.TrimSpace(string(e.Body))
+	if len(body) > 200 {
+		body = body[:200] + "..."
+	}
+	return fmt.Sprintf("%s %s retornou o status %d: %s", e.Method, e.URL, e.StatusCode, body)
+}
+
+// Temporary indica se o erro pode ser resolvido tentando novamente (erros 5xx e 429).
+func (e *HTTPError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// Request descreve uma chamada à API. Apenas as requisições idempotentes são repetidas em caso de falha;
+// GET, HEAD, PUT e DELETE são sempre consideradas idempotentes.
+type Request struct {
+	Method     string
+	Path       string
+	Body       interface{}
+	Idempotent bool
+}
+
+func (r Request) idempotent() bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Idempotent
+}
+
+// Client é um cliente HTTP para APIs JSON com URL base, timeout, autenticação e retentativas.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Auth       Authenticator
+	MaxRetries int
+	RetryDelay time.Duration
+}
+
+// NewClient cria um cliente para a URL base informada. Um timeout zero usa o padrão de 30 segundos.
+func NewClient(baseURL string, timeout time.Duration) *Client {
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: timeout},
+		MaxRetries: defaultMaxRetries,
+		RetryDelay: defaultRetryDelay,
+	}
+}
+
+// Get faz uma requisição GET e decodifica o JSON da resposta em `out`, se informado.
+func (c *Client) Get(ctx context.Context, path string, out interface{}) error {
+	return c.Do(ctx, Request{Method: http.MethodGet, Path: path}, out)
+}
+
+// Post faz uma requisição POST enviando `in` como JSON e decodifica o JSON da resposta em `out`, se informado.
+func (c *Client) Post(ctx context.Context, path string, in, out interface{}) error {
+	return c.Do(ctx, Request{Method: http.MethodPost, Path: path, Body: in}, out)
+}
+
+// Do executa a requisição e decodifica o JSON da resposta em `out`, se informado.
+func (c *Client) Do(ctx context.Context, req Request, out interface{}) error {
+	body, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("erro ao decodificar a resposta JSON de %s: %v", c.url(req.Path), err)
+	}
+	return nil
+}
+
+// send executa a requisição, repetindo-a em caso de falhas temporárias quando ela é idempotente,
+// e retorna o corpo da resposta.
+func (c *Client) send(ctx context.Context, req Request) ([]byte, error) {
+	var payload []byte
+	if req.Body != nil {
+		var err error
+		if payload, err = json.Marshal(req.Body); err != nil {
+			return nil, fmt.Errorf("erro ao codificar o corpo da requisição: %v", err)
+		}
+	}
+
+	attempts := 1
+	if req.idempotent() {
+		attempts += c.MaxRetries
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			metrics.Retries.WithLabelValues("cs_api").Inc()
+			// Espera com backoff exponencial antes de tentar novamente
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(c.RetryDelay * time.Duration(1<<(attempt-1))):
+			}
+		}
+
+		body, err := c.sendOnce(ctx, req, payload)
+		if err == nil {
+			return body, nil
+		}
+		lastErr = err
+		if !retryable(ctx, err) {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func (c *Client) sendOnce(ctx context.Context, req Request, payload []byte) ([]byte, error) {
+	var reader io.Reader
+	if payload != nil {
+		reader = bytes.NewReader(payload)
+	}
+
+	url := c.url(req.Path)
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if payload != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if c.Auth != nil {
+		if err := c.Auth.Authenticate(httpReq); err != nil {
+			return nil, fmt.Errorf("erro ao autenticar a requisição: %v", err)
+		}
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &HTTPError{Method: req.Method, URL: url, StatusCode: resp.StatusCode, Body: body}
+	}
+	return body, nil
+}
+
+func (c *Client) url(path string) string {
+	if c.BaseURL == "" || strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	return c.BaseURL + "/" + strings.TrimLeft(path, "/")
+}
+
+// retryable indica se vale a pena repetir a requisição após o erro: respostas temporárias da API, erros
+// de rede e timeouts. Erros de autenticação, de URL ou de cancelamento se repetiriam em toda tentativa.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Temporary()
+	}
+
+	// O http.Client envolve os erros em um url.Error, que por si só já satisfaz net.Error
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	// Conexão encerrada pelo servidor antes ou durante a resposta
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
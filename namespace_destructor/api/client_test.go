@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient cria um cliente para o servidor de teste, sem espera entre as tentativas.
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClient(server.URL, time.Second)
+	client.RetryDelay = time.Millisecond
+	return client, &calls
+}
+
+func TestClientRetriesTemporaryStatus(t *testing.T) {
+	var failures atomic.Int32
+	client, calls := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if failures.Add(1) <= 2 {
+			http.Error(w, "indisponível", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	})
+
+	var out struct{ OK bool }
+	if err := client.Get(context.Background(), "/status", &out); err != nil {
+		t.Fatalf("Get retornou erro: %v", err)
+	}
+	if !out.OK {
+		t.Errorf("resposta não decodificada: %+v", out)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("chamadas = %d, esperado 3", got)
+	}
+}
+
+func TestClientDoesNotRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		request Request
+		calls   int32
+	}{
+		{
+			name:    "erro do cliente",
+			handler: func(w http.ResponseWriter, r *http.Request) { http.Error(w, "inválido", http.StatusBadRequest) },
+			request: Request{Method: http.MethodGet, Path: "/"},
+			calls:   1,
+		},
+		{
+			name:    "json inválido",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("<html>")) },
+			request: Request{Method: http.MethodGet, Path: "/"},
+			calls:   1,
+		},
+		{
+			name: "post não idempotente",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "indisponível", http.StatusServiceUnavailable)
+			},
+			request: Request{Method: http.MethodPost, Path: "/", Body: map[string]string{}},
+			calls:   1,
+		},
+		{
+			name: "post idempotente",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "indisponível", http.StatusServiceUnavailable)
+			},
+			request: Request{Method: http.MethodPost, Path: "/", Body: map[string]string{}, Idempotent: true},
+			calls:   1 + defaultMaxRetries,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, calls := newTestClient(t, test.handler)
+			var out map[string]interface{}
+			if err := client.Do(context.Background(), test.request, &out); err == nil {
+				t.Fatal("Do não retornou erro")
+			}
+			if got := calls.Load(); got != test.calls {
+				t.Errorf("chamadas = %d, esperado %d", got, test.calls)
+			}
+		})
+	}
+}
+
+func TestClientHTTPError(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "não encontrado", http.StatusNotFound)
+	})
+
+	err := client.Get(context.Background(), "/subscriber", nil)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("erro = %v, esperado *HTTPError", err)
+	}
+	if httpErr.StatusCode != http.StatusNotFound || httpErr.Temporary() {
+		t.Errorf("HTTPError = %+v, esperado 404 não temporário", httpErr)
+	}
+}
+
+func TestClientDoesNotRetryAuthErrors(t *testing.T) {
+	client, calls := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	var attempts int
+	client.Auth = AuthFunc(func(req *http.Request) error {
+		attempts++
+		return errors.New("token expirado")
+	})
+
+	if err := client.Get(context.Background(), "/", nil); err == nil {
+		t.Fatal("Get não retornou erro")
+	}
+	if attempts != 1 || calls.Load() != 0 {
+		t.Errorf("tentativas = %d e chamadas = %d, esperado 1 e 0", attempts, calls.Load())
+	}
+}
+
+func TestClientRetriesTimeouts(t *testing.T) {
+	var slow atomic.Bool
+	slow.Store(true)
+	client, calls := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if slow.Swap(false) {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte(`{}`))
+	})
+	client.HTTPClient.Timeout = 50 * time.Millisecond
+
+	if err := client.Get(context.Background(), "/", nil); err != nil {
+		t.Fatalf("Get retornou erro: %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("chamadas = %d, esperado 2", got)
+	}
+}
+
+func TestClientRetriesClosedConnections(t *testing.T) {
+	var closed atomic.Bool
+	client, calls := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if !closed.Swap(true) {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+
+	if err := client.Get(context.Background(), "/", nil); err != nil {
+		t.Fatalf("Get retornou erro: %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("chamadas = %d, esperado 2", got)
+	}
+}
+
+func TestClientStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client, calls := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		http.Error(w, "indisponível", http.StatusServiceUnavailable)
+	})
+
+	if err := client.Get(ctx, "/", nil); err == nil {
+		t.Fatal("Get não retornou erro")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("chamadas = %d, esperado 1", got)
+	}
+}
+
+func TestClientAuthentication(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer segredo" {
+			http.Error(w, "não autorizado", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	client.Auth = BearerToken("segredo")
+
+	if err := client.Get(context.Background(), "/", nil); err != nil {
+		t.Fatalf("Get retornou erro: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FakeSubscriber é um assinante do FakeCSServer.
+type FakeSubscriber struct {
+	Name       string                        `json:"Name"`
+	Status     SubscriberGetSubscriberStatus `json:"Status"`
+	Namespaces []SubscriberNamespace         `json:"Namespaces"`
+}
+
+// FakeCSServer simula localmente os endpoints de assinantes do CS, permitindo rodar testes e dry runs
+// sem acesso ao CS real. Os dados ficam em memória e as marcações de dados destruídos ficam em Purged.
+type FakeCSServer struct {
+	APIKey   string
+	PageSize int
+
+	mu          sync.Mutex
+	subscribers map[string]FakeSubscriber
+	purged      []SubscriberSetDataPurged
+}
+
+// NewFakeCSServer cria o servidor falso com os assinantes informados, indexados pelo SubscriberUId do status.
+func NewFakeCSServer(apiKey string, subscribers []FakeSubscriber) *FakeCSServer {
+	server := &FakeCSServer{APIKey: apiKey, PageSize: 100, subscribers: make(map[string]FakeSubscriber)}
+	for _, subscriber := range subscribers {
+		server.subscribers[subscriber.Status.SubscriberUId] = subscriber
+	}
+	return server
+}
+
+// LoadFakeCSServer cria o servidor falso com os assinantes de um arquivo JSON (uma lista de FakeSubscriber).
+func LoadFakeCSServer(apiKey, filename string) (*FakeCSServer, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler o arquivo %s: %v", filename, err)
+	}
+	var subscribers []FakeSubscriber
+	if err := json.Unmarshal(data, &subscribers); err != nil {
+		return nil, fmt.Errorf("falha ao decodificar o arquivo %s: %v", filename, err)
+	}
+	return NewFakeCSServer(apiKey, subscribers), nil
+}
+
+// Purged retorna as marcações de dados destruídos recebidas pelo servidor, uma por assinante e namespace.
+func (s *FakeCSServer) Purged() []SubscriberSetDataPurged {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SubscriberSetDataPurged(nil), s.purged...)
+}
+
+func (s *FakeCSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeFakeError(w, http.StatusMethodNotAllowed, "método não suportado")
+		return
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, "corpo da requisição inválido")
+		return
+	}
+	if apiKey, _ := body["api_key"].(string); s.APIKey != "" && apiKey != s.APIKey {
+		writeFakeError(w, http.StatusUnauthorized, "api_key inválida")
+		return
+	}
+	field := func(name string) string {
+		value, _ := body[name].(string)
+		return value
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case getSubscriberStatusPath:
+		subscriber, ok := s.subscribers[field("SubscriberUuId")]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "assinante não encontrado")
+			return
+		}
+		writeFakeJSON(w, subscriber.Status)
+
+	case listSubscribersPath:
+		onlyInactive, _ := body["OnlyInactive"].(bool)
+		var uuids []string
+		for uuid, subscriber := range s.subscribers {
+			if onlyInactive && subscriber.Status.AccessActive != AccessInactive {
+				continue
+			}
+			uuids = append(uuids, uuid)
+		}
+		sort.Strings(uuids)
+
+		start, _ := strconv.Atoi(field("Cursor"))
+		end := min(start+s.PageSize, len(uuids))
+		page := SubscriberListSubscribers{Subscribers: []SubscriberListItem{}}
+		for _, uuid := range uuids[min(start, len(uuids)):end] {
+			subscriber := s.subscribers[uuid]
+			page.Subscribers = append(page.Subscribers, SubscriberListItem{
+				SubscriberUId: uuid,
+				Name:          subscriber.Name,
+				AccessActive:  subscriber.Status.AccessActive,
+				CSPeriod:      subscriber.Status.CSPeriod,
+			})
+		}
+		if end < len(uuids) {
+			page.NextCursor = strconv.Itoa(end)
+		}
+		writeFakeJSON(w, page)
+
+	case getSubscriberNamespacesPath:
+		subscriber, ok := s.subscribers[field("SubscriberUuId")]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "assinante não encontrado")
+			return
+		}
+		writeFakeJSON(w, SubscriberGetSubscriberNamespaces{Namespaces: subscriber.Namespaces})
+
+	case setDataPurgedPath:
+		uuid := field("SubscriberUuId")
+		if _, ok := s.subscribers[uuid]; !ok {
+			writeFakeError(w, http.StatusNotFound, "assinante não encontrado")
+			return
+		}
+		purgedAt := field("PurgedAt")
+		if purgedAt == "" {
+			purgedAt = time.Now().UTC().Format(time.RFC3339)
+		}
+		// A marcação é idempotente como no CS: repetir a chamada devolve a marcação já gravada
+		namespace := field("Namespace")
+		for _, existing := range s.purged {
+			if existing.SubscriberUId == uuid && existing.Namespace == namespace {
+				writeFakeJSON(w, existing)
+				return
+			}
+		}
+		response := SubscriberSetDataPurged{SubscriberUId: uuid, Namespace: namespace, DataPurged: true, PurgedAt: ParseDate(purgedAt)}
+		s.purged = append(s.purged, response)
+		writeFakeJSON(w, response)
+
+	default:
+		writeFakeError(w, http.StatusNotFound, "endpoint não encontrado")
+	}
+}
+
+func writeFakeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeFakeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeSubscriberClient inicia um FakeCSServer com três assinantes, dois deles inativos, e retorna o
+// cliente da API de assinantes apontando para ele.
+func newFakeSubscriberClient(t *testing.T) (*SubscriberClient, *FakeCSServer) {
+	t.Helper()
+	subscribers := []FakeSubscriber{
+		{Name: "Ativo", Status: SubscriberGetSubscriberStatus{SubscriberUId: "a", AccessActive: AccessActive, CSPeriod: ParseDate("2030-01-01")}},
+		{Name: "Cancelado", Status: SubscriberGetSubscriberStatus{SubscriberUId: "b", AccessActive: AccessInactive, CSPeriod: ParseDate("2023-01-01")},
+			Namespaces: []SubscriberNamespace{{SubscriberUId: "b", Namespace: "b.br.sp.campinas"}}},
+		{Name: "Suspenso", Status: SubscriberGetSubscriberStatus{SubscriberUId: "c", AccessActive: AccessInactive, CSPeriod: ParseDate("2023-06-01")}},
+	}
+	fake := NewFakeCSServer("chave", subscribers)
+	fake.PageSize = 2
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := NewSubscriberClient(SubscriberConfig{BaseURL: server.URL, APIKey: "chave", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewSubscriberClient retornou erro: %v", err)
+	}
+	client.Client().RetryDelay = time.Millisecond
+	return client, fake
+}
+
+func TestFakeCSServerStatus(t *testing.T) {
+	client, _ := newFakeSubscriberClient(t)
+
+	status, err := client.GetSubscriberStatus(context.Background(), "b")
+	if err != nil {
+		t.Fatalf("GetSubscriberStatus retornou erro: %v", err)
+	}
+	if status.IsActive() || !status.IsChurnedSince(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("status = %+v, esperado cancelado antes de 2024", status)
+	}
+
+	_, err = client.GetSubscriberStatus(context.Background(), "inexistente")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+		t.Errorf("erro = %v, esperado *HTTPError 404", err)
+	}
+}
+
+func TestFakeCSServerListPages(t *testing.T) {
+	client, _ := newFakeSubscriberClient(t)
+
+	all, err := client.ListSubscribers(context.Background(), false)
+	if err != nil {
+		t.Fatalf("ListSubscribers retornou erro: %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("assinantes = %d, esperado 3 em duas páginas", len(all))
+	}
+
+	inactive, err := client.ListSubscribers(context.Background(), true)
+	if err != nil {
+		t.Fatalf("ListSubscribers retornou erro: %v", err)
+	}
+	if len(inactive) != 2 || inactive[0].SubscriberUId != "b" || inactive[1].SubscriberUId != "c" {
+		t.Errorf("inativos = %+v, esperado b e c", inactive)
+	}
+}
+
+func TestFakeCSServerNamespacesAndPurge(t *testing.T) {
+	client, fake := newFakeSubscriberClient(t)
+
+	namespaces, err := client.GetSubscriberNamespaces(context.Background(), "b")
+	if err != nil {
+		t.Fatalf("GetSubscriberNamespaces retornou erro: %v", err)
+	}
+	if len(namespaces) != 1 || namespaces[0].Namespace != "b.br.sp.campinas" {
+		t.Fatalf("namespaces = %+v", namespaces)
+	}
+
+	purgedAt := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	response, err := client.SetDataPurged(context.Background(), "b", namespaces[0].Namespace, purgedAt)
+	if err != nil {
+		t.Fatalf("SetDataPurged retornou erro: %v", err)
+	}
+	if !response.DataPurged || !response.PurgedAt.Equal(purgedAt) {
+		t.Errorf("resposta = %+v", response)
+	}
+	if purged := fake.Purged(); len(purged) != 1 || purged[0].Namespace != "b.br.sp.campinas" {
+		t.Errorf("marcações = %+v", purged)
+	}
+}
+
+func TestFakeCSServerRejectsInvalidKey(t *testing.T) {
+	_, fake := newFakeSubscriberClient(t)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := NewSubscriberClient(SubscriberConfig{BaseURL: server.URL, APIKey: "outra", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewSubscriberClient retornou erro: %v", err)
+	}
+	_, err = client.GetSubscriberStatus(context.Background(), "a")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("erro = %v, esperado *HTTPError 401", err)
+	}
+}
+
+func TestFakeCSServerSetDataPurgedIsIdempotent(t *testing.T) {
+	_, fake := newFakeSubscriberClient(t)
+
+	// A primeira marcação é gravada, mas a resposta se perde: o cliente recebe 503 e tenta novamente
+	var lost atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == setDataPurgedPath && !lost.Swap(true) {
+			fake.ServeHTTP(httptest.NewRecorder(), r)
+			http.Error(w, "indisponível", http.StatusServiceUnavailable)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client, err := NewSubscriberClient(SubscriberConfig{BaseURL: server.URL, APIKey: "chave", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewSubscriberClient retornou erro: %v", err)
+	}
+	client.Client().RetryDelay = time.Millisecond
+
+	first := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	response, err := client.SetDataPurged(context.Background(), "b", "b.br.sp.campinas", first)
+	if err != nil {
+		t.Fatalf("SetDataPurged retornou erro: %v", err)
+	}
+	if !response.PurgedAt.Equal(first) {
+		t.Errorf("resposta = %+v, esperado a marcação de %s", response, first)
+	}
+
+	// Uma nova marcação do mesmo namespace devolve a já gravada
+	response, err = client.SetDataPurged(context.Background(), "b", "b.br.sp.campinas", first.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SetDataPurged retornou erro: %v", err)
+	}
+	if !response.PurgedAt.Equal(first) {
+		t.Errorf("resposta = %+v, esperado a marcação original", response)
+	}
+	if purged := fake.Purged(); len(purged) != 1 {
+		t.Errorf("marcações = %+v, esperado uma", purged)
+	}
+}
@@ -1,53 +1,19 @@
 package api
 
 import (
-	"bytes"
-	"encoding/json"
-	"io"
+	"context"
 	"net/http"
 )
 
+// defaultClient é usado pelas funções Get e Post, que recebem a URL completa.
+var defaultClient = NewClient("", defaultTimeout)
+
 // Função para fazer requisições GET e retornar o JSON da resposta
 func Get(url string) ([]byte, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	return body, nil
+	return defaultClient.send(context.Background(), Request{Method: http.MethodGet, Path: url})
 }
 
 // Função para fazer requisições POST e retornar o JSON da resposta
 func Post(url string, jsonObj interface{}) ([]byte, error) {
-	jsonData, err := json.Marshal(jsonObj)
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	return body, nil
+	return defaultClient.send(context.Background(), Request{Method: http.MethodPost, Path: url, Body: jsonObj})
 }
@@ -1,15 +1,67 @@
 package api
 
+import "time"
+
 // SubscriberGetSubscriberStatus representa a estrutura de resposta da API para obter o status de um assinante
 type SubscriberGetSubscriberStatus struct {
-	SubscriberUId string `json:"SubscriberUId"`
-	AccessActive  string `json:"AccessActive"`
-	CSPeriod      string `json:"CSPeriod"`
+	SubscriberUId string      `json:"SubscriberUId"`
+	AccessActive  AccessState `json:"AccessActive"`
+	CSPeriod      Date        `json:"CSPeriod"` // fim do período de acesso contratado
 	AddInfo       struct {
-		BillingDay       int64  `json:"BillingDay"`
-		PaymentForm      string `json:"PaymentForm"`
-		SubscriptionDate string `json:"SubscriptionDate"`
-		BusinessType     string `json:"BusinessType"`
-		SubscriptionType string `json:"SubscriptionType"`
+		BillingDay       int64            `json:"BillingDay"`
+		PaymentForm      PaymentForm      `json:"PaymentForm"`
+		SubscriptionDate Date             `json:"SubscriptionDate"`
+		BusinessType     BusinessType     `json:"BusinessType"`
+		SubscriptionType SubscriptionType `json:"SubscriptionType"`
 	} `json:"AddInfo"`
 }
+
+// IsActive indica se o assinante está com o acesso ativo.
+func (s SubscriberGetSubscriberStatus) IsActive() bool {
+	return s.AccessActive == AccessActive
+}
+
+// IsChurnedSince indica se o assinante está comprovadamente inativo desde antes de `t`: o acesso está
+// inativo e o período contratado terminou antes de `t`. Na dúvida (acesso desconhecido ou período não
+// reconhecido) retorna false, para que as verificações de segurança da deleção não o considerem cancelado.
+func (s SubscriberGetSubscriberStatus) IsChurnedSince(t time.Time) bool {
+	if s.AccessActive != AccessInactive || !s.CSPeriod.Valid() {
+		return false
+	}
+	return s.CSPeriod.Before(t)
+}
+
+// SubscriberListItem representa um assinante na resposta da API de listagem de assinantes
+type SubscriberListItem struct {
+	SubscriberUId string      `json:"SubscriberUId"`
+	Name          string      `json:"Name"`
+	AccessActive  AccessState `json:"AccessActive"`
+	CSPeriod      Date        `json:"CSPeriod"`
+}
+
+// SubscriberListSubscribers representa a estrutura de resposta da API para listar assinantes.
+// Quando NextCursor não é vazio, há mais páginas a serem buscadas.
+type SubscriberListSubscribers struct {
+	Subscribers []SubscriberListItem `json:"Subscribers"`
+	NextCursor  string               `json:"NextCursor"`
+}
+
+// SubscriberNamespace representa o vínculo entre um assinante e um namespace do Datastore
+type SubscriberNamespace struct {
+	SubscriberUId        string `json:"SubscriberUId"`
+	Namespace            string `json:"Namespace"`
+	SubscriberBucketName string `json:"SubscriberBucketName"`
+}
+
+// SubscriberGetSubscriberNamespaces representa a estrutura de resposta da API para obter os namespaces de um assinante
+type SubscriberGetSubscriberNamespaces struct {
+	Namespaces []SubscriberNamespace `json:"Namespaces"`
+}
+
+// SubscriberSetDataPurged representa a estrutura de resposta da API que marca os dados de um assinante como destruídos
+type SubscriberSetDataPurged struct {
+	SubscriberUId string `json:"SubscriberUId"`
+	Namespace     string `json:"Namespace"`
+	DataPurged    bool   `json:"DataPurged"`
+	PurgedAt      Date   `json:"PurgedAt"`
+}
@@ -1,38 +1,213 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
+	"sync"
+	"time"
 )
 
-// Função pública para buscar o status de um assinante e converter a resposta para a struct
-func GetSubscriberStatus(subscriberUuId string) (SubscriberGetSubscriberStatus, error) {
-	// Obtenha a api_key da variável de ambiente
-	apiKey := os.Getenv("API_KEY_CS")
-	if apiKey == "" {
-		log.Fatal("API_KEY_CS não configurada")
+const (
+	// DefaultCSBaseURL é a URL do CS usada quando CS_BASE_URL não está configurada.
+	DefaultCSBaseURL = "https://cs.clinicorp.tech"
+
+	getSubscriberStatusPath     = "/api/adm/subscriber/get_subscriber_status"
+	listSubscribersPath         = "/api/adm/subscriber/list_subscribers"
+	getSubscriberNamespacesPath = "/api/adm/subscriber/get_subscriber_namespaces"
+	setDataPurgedPath           = "/api/adm/subscriber/set_data_purged"
+)
+
+// SubscriberConfig contém a configuração do cliente da API de assinantes do CS.
+type SubscriberConfig struct {
+	BaseURL     string
+	APIKey      string
+	Timeout     time.Duration
+	CacheTTL    time.Duration // tempo em que um status fica em cache; zero desativa o cache
+	Concurrency int           // quantidade de requisições simultâneas nas consultas em lote
+}
+
+// SubscriberConfigFromEnv lê a configuração das variáveis de ambiente API_KEY_CS e CS_BASE_URL.
+func SubscriberConfigFromEnv() SubscriberConfig {
+	baseURL := os.Getenv("CS_BASE_URL")
+	if baseURL == "" {
+		baseURL = DefaultCSBaseURL
+	}
+	return SubscriberConfig{
+		BaseURL:     baseURL,
+		APIKey:      os.Getenv("API_KEY_CS"),
+		Timeout:     defaultTimeout,
+		CacheTTL:    10 * time.Minute,
+		Concurrency: 10,
+	}
+}
+
+// SubscriberClient consulta a API de assinantes do CS.
+// Os erros das respostas da API envolvem um *HTTPError, que pode ser obtido com errors.As.
+type SubscriberClient struct {
+	client      *Client
+	apiKey      string
+	concurrency int
+	cache       *ttlCache[SubscriberGetSubscriberStatus]
+}
+
+// NewSubscriberClient cria o cliente da API de assinantes, retornando um erro se a configuração for inválida.
+func NewSubscriberClient(config SubscriberConfig) (*SubscriberClient, error) {
+	if config.APIKey == "" {
+		return nil, fmt.Errorf("API_KEY_CS não configurada")
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = DefaultCSBaseURL
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	return &SubscriberClient{
+		client:      NewClient(config.BaseURL, config.Timeout),
+		apiKey:      config.APIKey,
+		concurrency: config.Concurrency,
+		cache:       newTTLCache[SubscriberGetSubscriberStatus](config.CacheTTL),
+	}, nil
+}
+
+// Client retorna o cliente HTTP usado nas requisições, permitindo ajustar retentativas e autenticação.
+func (c *SubscriberClient) Client() *Client {
+	return c.client
+}
+
+// GetSubscriberStatus busca o status de um assinante, usando o cache local quando disponível.
+func (c *SubscriberClient) GetSubscriberStatus(ctx context.Context, subscriberUuId string) (SubscriberGetSubscriberStatus, error) {
+	if status, ok := c.cache.get(subscriberUuId); ok {
+		return status, nil
 	}
 
-	// Crie o objeto JSON a ser enviado
 	requestBody := map[string]string{
-		"api_key":        apiKey,
+		"api_key":        c.apiKey,
 		"SubscriberUuId": subscriberUuId,
 	}
 
-	// Chame o método Post e obtenha o JSON de resposta
-	responseBody, err := Post("https://cs.clinicorp.tech/api/adm/subscriber/get_subscriber_status", requestBody)
+	// A consulta não altera nada no CS e pode ser repetida com segurança
+	var subscriberStatus SubscriberGetSubscriberStatus
+	err := c.client.Do(ctx, Request{Method: http.MethodPost, Path: getSubscriberStatusPath, Body: requestBody, Idempotent: true}, &subscriberStatus)
 	if err != nil {
-		return SubscriberGetSubscriberStatus{}, fmt.Errorf("erro ao fazer a requisição POST: %v", err)
+		return SubscriberGetSubscriberStatus{}, fmt.Errorf("erro ao buscar o status do assinante %s: %w", subscriberUuId, err)
 	}
 
-	// Decodifique a resposta JSON para a struct
-	var subscriberStatus SubscriberGetSubscriberStatus
-	err = json.Unmarshal(responseBody, &subscriberStatus)
+	c.cache.set(subscriberUuId, subscriberStatus)
+	return subscriberStatus, nil
+}
+
+// SubscriberStatusResult é o resultado da consulta de um assinante em uma busca em lote.
+type SubscriberStatusResult struct {
+	Status SubscriberGetSubscriberStatus
+	Err    error
+}
+
+// GetSubscriberStatuses busca o status de vários assinantes com no máximo `Concurrency` requisições
+// simultâneas. O resultado é indexado pelo UUID do assinante e contém o erro de cada consulta que falhou.
+func (c *SubscriberClient) GetSubscriberStatuses(ctx context.Context, subscriberUuIds []string) map[string]SubscriberStatusResult {
+	results := make(map[string]SubscriberStatusResult, len(subscriberUuIds))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	uuidCh := make(chan string)
+
+	for i := 0; i < c.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uuid := range uuidCh {
+				status, err := c.GetSubscriberStatus(ctx, uuid)
+				mu.Lock()
+				results[uuid] = SubscriberStatusResult{Status: status, Err: err}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	seen := make(map[string]bool, len(subscriberUuIds))
+	for _, uuid := range subscriberUuIds {
+		if seen[uuid] {
+			continue
+		}
+		seen[uuid] = true
+		uuidCh <- uuid
+	}
+	close(uuidCh)
+	wg.Wait()
+
+	return results
+}
+
+// ListSubscribers lista todos os assinantes do CS, percorrendo todas as páginas da API.
+// Com `onlyInactive` apenas os assinantes com o acesso inativo são retornados.
+func (c *SubscriberClient) ListSubscribers(ctx context.Context, onlyInactive bool) ([]SubscriberListItem, error) {
+	var subscribers []SubscriberListItem
+	cursor := ""
+	for {
+		requestBody := map[string]interface{}{
+			"api_key":      c.apiKey,
+			"Cursor":       cursor,
+			"OnlyInactive": onlyInactive,
+		}
+
+		var page SubscriberListSubscribers
+		err := c.client.Do(ctx, Request{Method: http.MethodPost, Path: listSubscribersPath, Body: requestBody, Idempotent: true}, &page)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao listar assinantes: %w", err)
+		}
+
+		subscribers = append(subscribers, page.Subscribers...)
+		if page.NextCursor == "" || page.NextCursor == cursor {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	return subscribers, nil
+}
+
+// GetSubscriberNamespaces busca os namespaces do Datastore vinculados a um assinante.
+func (c *SubscriberClient) GetSubscriberNamespaces(ctx context.Context, subscriberUuId string) ([]SubscriberNamespace, error) {
+	requestBody := map[string]string{
+		"api_key":        c.apiKey,
+		"SubscriberUuId": subscriberUuId,
+	}
+
+	var response SubscriberGetSubscriberNamespaces
+	err := c.client.Do(ctx, Request{Method: http.MethodPost, Path: getSubscriberNamespacesPath, Body: requestBody, Idempotent: true}, &response)
 	if err != nil {
-		return SubscriberGetSubscriberStatus{}, fmt.Errorf("erro ao decodificar a resposta JSON: %v", err)
+		return nil, fmt.Errorf("erro ao buscar os namespaces do assinante %s: %w", subscriberUuId, err)
 	}
+	return response.Namespaces, nil
+}
 
-	return subscriberStatus, nil
+// SetDataPurged marca no CS que os dados do namespace do assinante foram destruídos em `purgedAt`.
+// Marcar o mesmo namespace mais de uma vez não tem efeito, por isso a chamada pode ser repetida.
+func (c *SubscriberClient) SetDataPurged(ctx context.Context, subscriberUuId, namespace string, purgedAt time.Time) (SubscriberSetDataPurged, error) {
+	requestBody := map[string]string{
+		"api_key":        c.apiKey,
+		"SubscriberUuId": subscriberUuId,
+		"Namespace":      namespace,
+		"PurgedAt":       purgedAt.UTC().Format(time.RFC3339),
+	}
+
+	var response SubscriberSetDataPurged
+	err := c.client.Do(ctx, Request{Method: http.MethodPost, Path: setDataPurgedPath, Body: requestBody, Idempotent: true}, &response)
+	if err != nil {
+		return SubscriberSetDataPurged{}, fmt.Errorf("erro ao marcar os dados do assinante %s como destruídos: %w", subscriberUuId, err)
+	}
+
+	// O status em cache não reflete mais a situação do assinante
+	c.cache.delete(subscriberUuId)
+	return response, nil
+}
+
+// Função pública para buscar o status de um assinante e converter a resposta para a struct,
+// usando a configuração das variáveis de ambiente.
+func GetSubscriberStatus(subscriberUuId string) (SubscriberGetSubscriberStatus, error) {
+	client, err := NewSubscriberClient(SubscriberConfigFromEnv())
+	if err != nil {
+		return SubscriberGetSubscriberStatus{}, err
+	}
+	return client.GetSubscriberStatus(context.Background(), subscriberUuId)
 }
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rawString decodifica um valor JSON como texto, aceitando strings, números, booleanos e null.
+func rawString(data []byte) string {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		return text
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "null" {
+		return ""
+	}
+	return value
+}
+
+// AccessState representa o campo AccessActive do CS.
+type AccessState int
+
+const (
+	AccessUnknown AccessState = iota
+	AccessActive
+	AccessInactive
+)
+
+func (s AccessState) String() string {
+	switch s {
+	case AccessActive:
+		return "ativo"
+	case AccessInactive:
+		return "inativo"
+	}
+	return "desconhecido"
+}
+
+// UnmarshalJSON aceita booleanos e os textos usados pelo CS ("true", "1", "S", ...). Valores não
+// reconhecidos resultam em AccessUnknown em vez de erro.
+func (s *AccessState) UnmarshalJSON(data []byte) error {
+	switch strings.ToLower(rawString(data)) {
+	case "true", "1", "s", "sim", "y", "yes", "active", "ativo":
+		*s = AccessActive
+	case "false", "0", "n", "nao", "não", "no", "inactive", "inativo":
+		*s = AccessInactive
+	default:
+		*s = AccessUnknown
+	}
+	return nil
+}
+
+func (s AccessState) MarshalJSON() ([]byte, error) {
+	switch s {
+	case AccessActive:
+		return []byte(`"true"`), nil
+	case AccessInactive:
+		return []byte(`"false"`), nil
+	}
+	return []byte(`""`), nil
+}
+
+// timeLayouts são os formatos de data aceitos nos campos de data do CS.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"02/01/2006 15:04:05",
+	"02/01/2006",
+	"20060102",
+	"2006-01",
+}
+
+// Date é uma data do CS. O texto original é mantido em Raw e Time fica zerado quando
+// o formato não é reconhecido.
+type Date struct {
+	time.Time
+	Raw string
+}
+
+// ParseDate interpreta uma data em qualquer um dos formatos conhecidos ou em milissegundos desde 1970.
+func ParseDate(value string) Date {
+	date := Date{Raw: value}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return date
+	}
+	for _, layout := range timeLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			date.Time = parsed
+			return date
+		}
+	}
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil && len(value) >= 12 {
+		date.Time = time.UnixMilli(millis).UTC()
+	}
+	return date
+}
+
+// Valid indica se a data foi reconhecida.
+func (d Date) Valid() bool {
+	return !d.Time.IsZero()
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	*d = ParseDate(rawString(data))
+	return nil
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Raw)
+}
+
+// PaymentForm é a forma de pagamento do assinante. Valores desconhecidos são mantidos como vieram do CS.
+type PaymentForm string
+
+const (
+	PaymentFormBoleto     PaymentForm = "BOLETO"
+	PaymentFormCreditCard PaymentForm = "CREDIT_CARD"
+	PaymentFormPix        PaymentForm = "PIX"
+)
+
+func (p *PaymentForm) UnmarshalJSON(data []byte) error {
+	*p = PaymentForm(strings.ToUpper(strings.TrimSpace(rawString(data))))
+	return nil
+}
+
+// Known indica se a forma de pagamento é uma das conhecidas.
+func (p PaymentForm) Known() bool {
+	switch p {
+	case PaymentFormBoleto, PaymentFormCreditCard, PaymentFormPix:
+		return true
+	}
+	return false
+}
+
+// BusinessType é o tipo de negócio do assinante. Valores desconhecidos são mantidos como vieram do CS.
+type BusinessType string
+
+const (
+	BusinessTypeDental     BusinessType = "DENTAL"
+	BusinessTypeMedical    BusinessType = "MEDICAL"
+	BusinessTypeAesthetics BusinessType = "AESTHETICS"
+)
+
+func (b *BusinessType) UnmarshalJSON(data []byte) error {
+	*b = BusinessType(strings.ToUpper(strings.TrimSpace(rawString(data))))
+	return nil
+}
+
+// Known indica se o tipo de negócio é um dos conhecidos.
+func (b BusinessType) Known() bool {
+	switch b {
+	case BusinessTypeDental, BusinessTypeMedical, BusinessTypeAesthetics:
+		return true
+	}
+	return false
+}
+
+// SubscriptionType é o tipo de assinatura. Valores desconhecidos são mantidos como vieram do CS.
+type SubscriptionType string
+
+const (
+	SubscriptionTypeMonthly SubscriptionType = "MONTHLY"
+	SubscriptionTypeAnnual  SubscriptionType = "ANNUAL"
+	SubscriptionTypeTrial   SubscriptionType = "TRIAL"
+)
+
+func (s *SubscriptionType) UnmarshalJSON(data []byte) error {
+	*s = SubscriptionType(strings.ToUpper(strings.TrimSpace(rawString(data))))
+	return nil
+}
+
+// Known indica se o tipo de assinatura é um dos conhecidos.
+func (s SubscriptionType) Known() bool {
+	switch s {
+	case SubscriptionTypeMonthly, SubscriptionTypeAnnual, SubscriptionTypeTrial:
+		return true
+	}
+	return false
+}
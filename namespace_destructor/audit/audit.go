@@ -0,0 +1,255 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"namespace_destructor/namespace_list"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// Ações destrutivas registradas na auditoria.
+const (
+	ActionDeleteNamespace     = "delete_namespace"
+	ActionDeleteReferences    = "delete_references"
+	ActionQuarantine          = "quarantine_references"
+	ActionDeleteObjects       = "delete_objects"
+	ActionQuarantineNamespace = "quarantine_namespace"
+	ActionRestoreNamespace    = "restore_namespace"
+	ActionBreakLock           = "break_lock"
+)
+
+// PhaseIntent marca o registro gravado antes da ação. O registro do resultado, sem fase, é gravado
+// depois; uma intenção sem o resultado indica uma execução interrompida.
+const PhaseIntent = "intent"
+
+// Entry é um registro da auditoria. Cada registro guarda o hash do anterior (PrevHash), formando uma
+// cadeia: alterar ou remover um registro invalida o hash de todos os seguintes. Com uma chave
+// configurada, o hash também é assinado com HMAC-SHA256, impedindo que a cadeia seja refeita.
+type Entry struct {
+	Seq        int64            `json:"seq"`
+	Action     string           `json:"action"`
+	Phase      string           `json:"phase,omitempty"`
+	Operator   string           `json:"operator"`
+	Host       string           `json:"host"`
+	RunID      string           `json:"runId"`
+	Project    string           `json:"project"`
+	Namespace  string           `json:"namespace,omitempty"`
+	Target     string           `json:"target,omitempty"`   // namespace de quarentena que recebeu ou forneceu os dados
+	ReadTime   string           `json:"readTime,omitempty"` // instante lido na restauração PITR (RFC3339)
+	Bucket     string           `json:"bucket,omitempty"`
+	LockOwner  string           `json:"lockOwner,omitempty"` // dono do lock removido à força
+	Kinds      []string         `json:"kinds,omitempty"`
+	Counts     map[string]int64 `json:"counts"`
+	StartedAt  time.Time        `json:"startedAt"`
+	FinishedAt time.Time        `json:"finishedAt"`
+	ConfigHash string           `json:"configHash"`
+	PlanHash   string           `json:"planHash,omitempty"`      // hash do plano executado pelo apply
+	Verified   bool             `json:"verifiedEmpty,omitempty"` // namespace verificado vazio depois da deleção
+	Error      string           `json:"error,omitempty"`
+	PrevHash   string           `json:"prevHash"`
+	Hash       string           `json:"hash"`
+	Signature  string           `json:"signature,omitempty"`
+}
+
+// computeHash calcula o hash do registro sem os campos Hash e Signature.
+func (e Entry) computeHash() (string, error) {
+	e.Hash, e.Signature = "", ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("falha ao codificar o registro de auditoria: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func sign(key []byte, hash string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sink recebe uma cópia de cada registro gravado, ex: o kind de auditoria no Datastore.
+type Sink interface {
+	Write(ctx context.Context, entry Entry) error
+}
+
+// Log grava os registros de auditoria em um arquivo local, apenas acrescentando linhas JSON.
+type Log struct {
+	Path       string
+	Key        []byte // chave do HMAC, opcional
+	RunID      string
+	ConfigHash string
+	Sink       Sink // destino adicional, opcional
+
+	mu sync.Mutex
+}
+
+// NewLog cria o Log. O arquivo só é criado no primeiro registro.
+func NewLog(path string, key []byte, runID, configHash string) *Log {
+	return &Log{Path: path, Key: key, RunID: runID, ConfigHash: configHash}
+}
+
+// Record completa o registro (operador, máquina, horário de término e encadeamento), acrescenta ao
+// arquivo e envia ao Sink. Uma falha no Sink é apenas registrada no log, pois o arquivo local é a
+// fonte principal da auditoria. A leitura do último registro e a gravação são feitas com o arquivo
+// travado (flock), para que dois processos não continuem a cadeia a partir do mesmo registro.
+func (l *Log) Record(ctx context.Context, entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Operator = Operator()
+	entry.Host, _ = os.Hostname()
+	entry.RunID = l.RunID
+	entry.ConfigHash = l.ConfigHash
+	entry.FinishedAt = time.Now().UTC()
+	entry.StartedAt = entry.StartedAt.UTC()
+
+	unlock, err := namespace_list.LockFile(l.Path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Lê o último registro a cada gravação para continuar a cadeia mesmo que outra execução
+	// tenha escrito no arquivo
+	last, err := lastEntry(l.Path)
+	if err != nil {
+		return err
+	}
+	entry.Seq = last.Seq + 1
+	entry.PrevHash = last.Hash
+
+	if entry.Hash, err = entry.computeHash(); err != nil {
+		return err
+	}
+	if len(l.Key) > 0 {
+		entry.Signature = sign(l.Key, entry.Hash)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("falha ao codificar o registro de auditoria: %v", err)
+	}
+	file, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("falha ao abrir o arquivo de auditoria %s: %v", l.Path, err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("falha ao escrever no arquivo de auditoria %s: %v", l.Path, err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("falha ao gravar o arquivo de auditoria %s: %v", l.Path, err)
+	}
+
+	slog.Info("Registro de auditoria gravado", "action", entry.Action, "namespace", entry.Namespace, "seq", entry.Seq)
+	if l.Sink != nil {
+		if err := l.Sink.Write(ctx, entry); err != nil {
+			slog.Error("Falha ao enviar o registro de auditoria", "seq", entry.Seq, "error", err)
+		}
+	}
+	return nil
+}
+
+// Intent grava o registro da ação antes que ela seja executada, com a fase PhaseIntent. Se o registro
+// falhar, quem chama não deve executar a ação, para que nenhuma destruição fique sem rastro.
+func (l *Log) Intent(ctx context.Context, entry Entry) error {
+	entry.Phase = PhaseIntent
+	if err := l.Record(ctx, entry); err != nil {
+		return fmt.Errorf("falha ao registrar a intenção na auditoria: %v", err)
+	}
+	return nil
+}
+
+// Operator retorna quem executou o comando: DESTRUCTOR_OPERATOR ou o usuário do sistema.
+func Operator() string {
+	if operator := os.Getenv("DESTRUCTOR_OPERATOR"); operator != "" {
+		return operator
+	}
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return "desconhecido"
+}
+
+// lastEntry retorna o último registro do arquivo, ou um registro vazio se o arquivo não existir.
+func lastEntry(path string) (Entry, error) {
+	var last Entry
+	err := readEntries(path, func(entry Entry) error {
+		last = entry
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return Entry{}, nil
+	}
+	return last, err
+}
+
+func readEntries(path string, fn func(Entry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("linha %d do arquivo de auditoria %s inválida: %v", line, path, err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("erro ao ler o arquivo de auditoria %s: %v", path, err)
+	}
+	return nil
+}
+
+// Verify confere a cadeia de hashes do arquivo e, com uma chave, as assinaturas. Retorna a quantidade
+// de registros verificados e o primeiro problema encontrado.
+func Verify(path string, key []byte) (int, error) {
+	count := 0
+	previous := Entry{}
+	err := readEntries(path, func(entry Entry) error {
+		if entry.Seq != previous.Seq+1 {
+			return fmt.Errorf("registro %d fora de sequência, esperado %d", entry.Seq, previous.Seq+1)
+		}
+		if entry.PrevHash != previous.Hash {
+			return fmt.Errorf("registro %d não aponta para o registro anterior", entry.Seq)
+		}
+		hash, err := entry.computeHash()
+		if err != nil {
+			return err
+		}
+		if hash != entry.Hash {
+			return fmt.Errorf("registro %d foi alterado: hash não confere", entry.Seq)
+		}
+		if len(key) > 0 && !hmac.Equal([]byte(sign(key, entry.Hash)), []byte(entry.Signature)) {
+			return fmt.Errorf("registro %d com assinatura inválida", entry.Seq)
+		}
+		previous = entry
+		count++
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// DefaultKind é o kind padrão dos registros de auditoria no Datastore.
+const DefaultKind = "DestructorAudit"
+
+// DatastoreSink grava os registros de auditoria em um kind do namespace padrão do Datastore.
+// A chave é o hash do registro, então reenviar o mesmo registro não cria duplicatas.
+type DatastoreSink struct {
+	ProjectID string
+	Kind      string
+
+	once   sync.Once
+	client *datastore.Client
+	err    error
+}
+
+// datastoreEntry é o formato do registro no Datastore. Os contadores são gravados como texto
+// "kind=quantidade", pois o Datastore não aceita mapas com chaves arbitrárias.
+type datastoreEntry struct {
+	Seq        int64
+	Action     string
+	Phase      string
+	Operator   string
+	Host       string
+	RunID      string
+	Project    string
+	Namespace  string
+	Target     string
+	ReadTime   string
+	LockOwner  string
+	Bucket     string   `datastore:",noindex"`
+	Kinds      []string `datastore:",noindex"`
+	Counts     []string `datastore:",noindex"`
+	Total      int64
+	StartedAt  time.Time
+	FinishedAt time.Time
+	ConfigHash string `datastore:",noindex"`
+	PlanHash   string
+	Verified   bool
+	Error      string `datastore:",noindex"`
+	PrevHash   string `datastore:",noindex"`
+	Signature  string `datastore:",noindex"`
+}
+
+// Write grava o registro. O cliente é criado na primeira gravação.
+func (s *DatastoreSink) Write(ctx context.Context, entry Entry) error {
+	s.once.Do(func() {
+		s.client, s.err = datastore.NewClient(ctx, s.ProjectID)
+	})
+	if s.err != nil {
+		return fmt.Errorf("falha ao criar o cliente do Datastore da auditoria: %v", s.err)
+	}
+
+	record := datastoreEntry{
+		Seq: entry.Seq, Action: entry.Action, Phase: entry.Phase, Operator: entry.Operator, Host: entry.Host, RunID: entry.RunID,
+		Project: entry.Project, Namespace: entry.Namespace, Target: entry.Target, ReadTime: entry.ReadTime, Bucket: entry.Bucket, LockOwner: entry.LockOwner, Kinds: entry.Kinds,
+		StartedAt: entry.StartedAt, FinishedAt: entry.FinishedAt, ConfigHash: entry.ConfigHash, PlanHash: entry.PlanHash,
+		Verified: entry.Verified, Error: entry.Error, PrevHash: entry.PrevHash, Signature: entry.Signature,
+	}
+	for name, count := range entry.Counts {
+		record.Counts = append(record.Counts, fmt.Sprintf("%s=%d", name, count))
+		record.Total += count
+	}
+
+	kind := s.Kind
+	if kind == "" {
+		kind = DefaultKind
+	}
+	key := datastore.NameKey(kind, entry.Hash, nil)
+	if _, err := s.client.Put(ctx, key, &record); err != nil {
+		return fmt.Errorf("falha ao gravar o registro %d no kind %s: %v", entry.Seq, kind, err)
+	}
+	return nil
+}
+
+// Close encerra o cliente do Datastore, se tiver sido criado.
+func (s *DatastoreSink) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	return s.client.Close()
+}
@@ -2,16 +2,26 @@ package clone_data
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"namespace_destructor/get_data"
+	"namespace_destructor/lock"
+	"namespace_destructor/metrics"
+	"namespace_destructor/progress"
+	"namespace_destructor/ratelimit"
+	"time"
 
 	"cloud.google.com/go/datastore"
 	"google.golang.org/api/iterator"
 )
 
+// BatchSize é a quantidade de entidades inseridas por PutMulti
+var BatchSize = 500
+
 // CloneData copia todas as tabelas e registros do namespace do projeto de origem para o projeto de destino.
-func CloneData(ctx context.Context, sourceProjectID, destProjectID, namespace string) error {
+// O andamento de cada kind é informado ao `tracker`, que pode ser nil.
+func CloneData(ctx context.Context, sourceProjectID, destProjectID, namespace string, tracker *progress.Tracker) error {
 	sourceClient, err := datastore.NewClient(ctx, sourceProjectID)
 	if err != nil {
 		return fmt.Errorf("falha ao criar cliente do projeto de origem: %v", err)
@@ -24,32 +34,68 @@ func CloneData(ctx context.Context, sourceProjectID, destProjectID, namespace st
 	}
 	defer destClient.Close()
 
+	// O lock fica no projeto de destino, onde os dados são gravados
+	lease, ctx, err := lock.Acquire(ctx, destClient, namespace, lock.OperationClone)
+	if err != nil {
+		return err
+	}
+	defer lease.Release()
+
+	_, err = CopyNamespace(ctx, sourceClient, destClient, namespace, namespace, tracker)
+	return err
+}
+
+// CopyNamespace copia todos os kinds de `sourceNamespace`, no projeto do cliente de origem, para
+// `destNamespace`, no projeto do cliente de destino, mantendo as chaves e os ancestrais. Os dois clientes
+// podem ser o mesmo, copiando entre namespaces de um projeto. A falha de um kind não interrompe os demais;
+// os erros são retornados juntos. Retorna a quantidade de entidades copiadas por kind.
+func CopyNamespace(ctx context.Context, sourceClient, destClient *datastore.Client, sourceNamespace, destNamespace string, tracker *progress.Tracker) (map[string]int64, error) {
 	// Lista todas as tabelas no namespace
-	kinds, err := get_data.ListKinds(ctx, sourceClient, namespace)
+	kinds, err := get_data.ListKinds(ctx, sourceClient, sourceNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao listar kinds: %v", err)
+	}
+
+	// As estatísticas só são usadas para estimar o tempo restante, então a falha não interrompe a clonagem
+	counts, err := get_data.KindEntityCounts(ctx, sourceClient, sourceNamespace)
 	if err != nil {
-		return fmt.Errorf("falha ao listar kinds: %v", err)
+		slog.Warn("Não foi possível obter a quantidade de registros dos kinds", "namespace", sourceNamespace, "error", err)
 	}
 
+	copied := make(map[string]int64, len(kinds))
+	var errs []error
 	for _, kind := range kinds {
-		fmt.Printf("Clonando registros da tabela %s...\n", kind)
-		if err := cloneKindData(ctx, sourceClient, destClient, kind, namespace); err != nil {
-			log.Printf("Falha ao clonar registros para a tabela %s: %v", kind, err)
+		if ctx.Err() != nil {
+			return copied, fmt.Errorf("clonagem do namespace %s interrompida: %v", sourceNamespace, ctx.Err())
+		}
+		slog.Info("Clonando registros", "namespace", sourceNamespace, "kind", kind, "dest", destNamespace)
+		task := tracker.StartTask(destNamespace, kind, "clonando", counts[kind])
+		err := cloneKindData(ctx, sourceClient, destClient, kind, sourceNamespace, destNamespace, task)
+		copied[kind] = int64(task.Done())
+		metrics.EntitiesCloned.WithLabelValues(metrics.Project).Add(float64(task.Done()))
+		if err != nil {
+			slog.Error("Falha ao clonar registros", "namespace", sourceNamespace, "kind", kind, "cloned", task.Done(), "error", err)
+			metrics.BatchesFailed.WithLabelValues(metrics.Project, "clone").Inc()
+			errs = append(errs, fmt.Errorf("kind %s: %v", kind, err))
 		} else {
-			fmt.Printf("Clonagem de %s concluída.\n", kind)
+			slog.Info("Clonagem do kind concluída", "namespace", sourceNamespace, "kind", kind, "cloned", task.Done())
 		}
+		task.Finish()
 	}
 
-	fmt.Println("Processo de clonagem completo.")
-	return nil
+	slog.Info("Processo de clonagem completo", "namespace", sourceNamespace, "dest", destNamespace)
+	if len(errs) > 0 {
+		return copied, fmt.Errorf("falha ao clonar o namespace %s: %w", sourceNamespace, errors.Join(errs...))
+	}
+	return copied, nil
 }
 
-func cloneKindData(ctx context.Context, sourceClient, destClient *datastore.Client, kind, namespace string) error {
-	query := datastore.NewQuery(kind).Namespace(namespace)
+func cloneKindData(ctx context.Context, sourceClient, destClient *datastore.Client, kind, sourceNamespace, destNamespace string, task *progress.Task) error {
+	query := datastore.NewQuery(kind).Namespace(sourceNamespace)
 	it := sourceClient.Run(ctx, query)
 
 	var entities []datastore.PropertyList
 	var keys []*datastore.Key
-	batchSize := 500
 
 	for {
 		var entity datastore.PropertyList
@@ -61,28 +107,16 @@ func cloneKindData(ctx context.Context, sourceClient, destClient *datastore.Clie
 			return fmt.Errorf("falha ao iterar registros: %v", err)
 		}
 
-		// Cria a chave com o namespace especificado
-		if key.ID != 0 {
-			keys = append(keys, &datastore.Key{
-				Kind:      kind,
-				ID:        key.ID,
-				Namespace: namespace,
-			})
-		} else {
-			keys = append(keys, &datastore.Key{
-				Kind:      kind,
-				Name:      key.Name,
-				Namespace: namespace,
-			})
-		}
-
+		// Cria a chave, com os ancestrais, no namespace de destino
+		keys = append(keys, withNamespace(key, destNamespace))
 		entities = append(entities, entity)
 
 		// Processa o batch se o tamanho for alcançado
-		if len(entities) == batchSize {
-			if err := putEntities(ctx, destClient, keys, entities, namespace); err != nil {
+		if len(entities) == BatchSize {
+			if err := PutEntities(ctx, destClient, keys, entities); err != nil {
 				return err
 			}
+			task.Add(len(entities))
 			keys = nil
 			entities = nil
 		}
@@ -90,21 +124,32 @@ func cloneKindData(ctx context.Context, sourceClient, destClient *datastore.Clie
 
 	// Insere qualquer entidade restante
 	if len(entities) > 0 {
-		if err := putEntities(ctx, destClient, keys, entities, namespace); err != nil {
+		if err := PutEntities(ctx, destClient, keys, entities); err != nil {
 			return err
 		}
+		task.Add(len(entities))
 	}
 
 	return nil
 }
 
-func putEntities(ctx context.Context, client *datastore.Client, keys []*datastore.Key, entities []datastore.PropertyList, namespace string) error {
-	// Define o namespace nas entidades ao inseri-las no projeto de destino
-	for i := range keys {
-		keys[i].Namespace = namespace
+// withNamespace retorna uma cópia da chave e dos seus ancestrais no namespace informado.
+func withNamespace(key *datastore.Key, namespace string) *datastore.Key {
+	if key == nil {
+		return nil
 	}
+	return &datastore.Key{Kind: key.Kind, ID: key.ID, Name: key.Name, Parent: withNamespace(key.Parent, namespace), Namespace: namespace}
+}
 
+// PutEntities insere um lote de entidades respeitando o limitador de operações, que reduz a taxa
+// quando o Datastore responde com sobrecarga.
+func PutEntities(ctx context.Context, client *datastore.Client, keys []*datastore.Key, entities []datastore.PropertyList) error {
+	if err := ratelimit.Default.Wait(ctx, len(keys)); err != nil {
+		return err
+	}
+	defer metrics.ObserveDatastore("put_multi", time.Now())
 	_, err := client.PutMulti(ctx, keys, entities)
+	ratelimit.Default.Observe(err)
 	if err != nil {
 		return fmt.Errorf("falha ao inserir registros no projeto de destino: %v", err)
 	}
@@ -1,15 +1,72 @@
 package main
 
 import (
-	"log"
+	"namespace_destructor/audit"
+	"namespace_destructor/clone_data"
+	"namespace_destructor/config"
+	"namespace_destructor/delete_data"
+	"namespace_destructor/logger"
+	"namespace_destructor/metrics"
+	"namespace_destructor/progress"
+	"namespace_destructor/ratelimit"
+	"namespace_destructor/report"
+	"namespace_destructor/worklist"
+	"os"
+)
+
+var (
+	// cfg é a configuração carregada no início da execução
+	cfg *config.Config
+
+	// auditLog registra as ações destrutivas executadas
+	auditLog *audit.Log
+
+	// runReport registra os namespaces deletados e verificados vazios na execução
+	runReport *report.Report
 
-	"github.com/joho/godotenv"
+	// namespacesSource é a origem da lista de namespaces a serem destruídos
+	namespacesSource worklist.Source
+
+	// tracker mostra o andamento das deleções e clonagens. Os logs passam por ele para não corromper o painel
+	tracker *progress.Tracker
 )
 
-func LoadConfig() {
-	// Carrega as variáveis de ambiente do arquivo .env
-	err := godotenv.Load()
+func LoadConfig(flags *config.Flags) {
+	// Combina os padrões, o arquivo de configuração, o .env, as variáveis de ambiente e as flags
+	loaded, err := config.Load(flags)
 	if err != nil {
-		log.Fatalf("Erro ao carregar o arquivo .env: %v", err)
+		logger.Fatal("Erro ao carregar a configuração", "error", err)
+	}
+	cfg = loaded
+
+	tracker = progress.New(os.Stdout)
+	if err := logger.Setup(tracker, cfg.LogFormat, cfg.LogLevel); err != nil {
+		logger.Fatal("Erro ao configurar os logs", "error", err)
+	}
+
+	delete_data.BatchSize = cfg.DeleteBatchSize
+	delete_data.SetMaxKinds(cfg.MaxKinds)
+	clone_data.BatchSize = cfg.CloneBatchSize
+	metrics.Project = cfg.ProjectID
+
+	source := cfg.NamespacesSource
+	if source == "" {
+		source = cfg.NamespacesFile
+	}
+	if namespacesSource, err = worklist.Parse(source); err != nil {
+		logger.Fatal("Erro ao carregar a configuração", "error", err)
+	}
+	ratelimit.Default = ratelimit.New(cfg.OpsPerSecond)
+
+	auditLog = audit.NewLog(cfg.AuditFile, []byte(cfg.AuditKey), logger.RunID, cfg.Hash())
+	if cfg.AuditKind != "" {
+		auditLog.Sink = &audit.DatastoreSink{ProjectID: cfg.ProjectID, Kind: cfg.AuditKind}
+	}
+	runReport = report.New(cfg.ReportDir, logger.RunID, cfg.ProjectID)
+
+	if cfg.MetricsAddr != "" {
+		if err := metrics.Serve(cfg.MetricsAddr); err != nil {
+			logger.Fatal("Erro ao iniciar o servidor de métricas", "error", err)
+		}
 	}
 }
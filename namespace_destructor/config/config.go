@@ -0,0 +1,443 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Perfis disponíveis. O perfil define os valores padrão, ex: o projeto do Datastore usado.
+const (
+	ProfileDev  = "dev"
+	ProfileProd = "prod"
+)
+
+const (
+	ProjectDevId  = "dev-clinicorp"
+	ProjectProdId = "clinicorp-solution"
+
+	// DefaultFile é o arquivo de configuração lido quando nenhum outro é informado.
+	DefaultFile = "destructor.yaml"
+)
+
+// Config contém toda a configuração do destructor. Os valores são combinados na seguinte ordem
+// de precedência: padrões do perfil < arquivo YAML < variáveis de ambiente < flags da linha de comando.
+type Config struct {
+	Profile string `yaml:"-"`
+
+	ProjectID            string `yaml:"project"`              // projeto onde os comandos leem e deletam dados
+	CloneSourceProjectID string `yaml:"cloneSource"`          // projeto de origem da clonagem de dados
+	MaxTables            int    `yaml:"maxTables"`            // kinds deletados simultaneamente em um namespace
+	ConcurrentNamespaces int    `yaml:"concurrentNamespaces"` // namespaces processados simultaneamente
+	MaxKinds             int    `yaml:"maxKinds"`             // kinds deletados simultaneamente somando todos os namespaces (0 sem limite global)
+	DeleteBatchSize      int    `yaml:"deleteBatch"`          // chaves por DeleteMulti
+	CloneBatchSize       int    `yaml:"cloneBatch"`           // entidades por PutMulti
+	StorageWorkers       int    `yaml:"storageWorkers"`       // operações simultâneas no Cloud Storage
+
+	// OpsPerSecond limita as operações em entidades do Datastore por segundo, somando deleções,
+	// clonagens e varreduras (0 sem limite)
+	OpsPerSecond float64 `yaml:"opsPerSecond"`
+
+	NamespacesFile     string `yaml:"namespacesFile"`
+	SafeNamespacesFile string `yaml:"safeNamespacesFile"`
+
+	// NamespacesSource é a origem da lista de namespaces: um arquivo, `-` (stdin), `csv:<arquivo>:<coluna>`,
+	// `query:<filtro>` ou `cancelled[:<dias>]`. Vazio usa o NamespacesFile
+	NamespacesSource string `yaml:"namespacesSource"`
+
+	// NamespaceFilters são as consultas de namespaces salvas, usadas com `query:<nome>`
+	NamespaceFilters map[string]NamespaceFilter `yaml:"namespaceFilters"`
+
+	LogFormat string `yaml:"logFormat"` // text ou json
+	LogLevel  string `yaml:"logLevel"`  // debug, info, warn ou error
+
+	MetricsAddr string `yaml:"metricsAddr"` // endereço do servidor de métricas, ex: ":9090" (vazio desativa)
+
+	AuditFile string `yaml:"auditFile"` // arquivo local da auditoria das ações destrutivas
+	AuditKey  string `yaml:"auditKey"`  // chave do HMAC que assina os registros da auditoria (obrigatória em produção)
+	AuditKind string `yaml:"auditKind"` // kind do Datastore que recebe uma cópia da auditoria (vazio desativa)
+
+	ReportDir string `yaml:"reportDir"` // diretório dos relatórios de execução, um arquivo por execução
+
+	// Quarantine copia cada namespace para `__quarantine__.<namespace>.<data>` antes de deletá-lo. A cópia
+	// pode ser restaurada com o comando restore e é removida pelo purge-quarantine depois da retenção
+	Quarantine          bool          `yaml:"quarantine"`
+	QuarantineRetention time.Duration `yaml:"quarantineRetention"`
+
+	CS CSConfig `yaml:"cs"`
+}
+
+// CSConfig contém a configuração da API de assinantes do CS.
+type CSConfig struct {
+	BaseURL string        `yaml:"baseURL"`
+	APIKey  string        `yaml:"apiKey"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// NamespaceFilter é uma consulta salva em __namespace__. Os critérios informados são combinados.
+type NamespaceFilter struct {
+	Prefix   string `yaml:"prefix"`   // prefixo do namespace, consultado como um intervalo de chaves
+	Contains string `yaml:"contains"` // texto que o namespace deve conter
+	Pattern  string `yaml:"pattern"`  // expressão regular que o namespace deve satisfazer
+	Exclude  string `yaml:"exclude"`  // expressão regular dos namespaces descartados
+}
+
+// Empty indica se o filtro não tem nenhum critério de seleção. O Exclude sozinho não conta, pois o filtro
+// ainda selecionaria quase todos os namespaces do projeto.
+func (f NamespaceFilter) Empty() bool {
+	return f.Prefix == "" && f.Contains == "" && f.Pattern == ""
+}
+
+// fileConfig é o formato do arquivo YAML: os valores comuns ficam na raiz e cada perfil pode
+// sobrescrevê-los em `profiles`.
+type fileConfig struct {
+	Config   `yaml:",inline"`
+	Profiles map[string]yaml.Node `yaml:"profiles"`
+}
+
+// Defaults retorna a configuração padrão do perfil.
+func Defaults(profile string) Config {
+	config := Config{
+		Profile:              profile,
+		ProjectID:            ProjectDevId,
+		CloneSourceProjectID: ProjectProdId,
+		MaxTables:            4,
+		ConcurrentNamespaces: 1,
+		DeleteBatchSize:      1000,
+		CloneBatchSize:       500,
+		StorageWorkers:       100,
+		NamespacesFile:       "namespaces.txt",
+		SafeNamespacesFile:   "safeNamespaces.txt",
+		LogFormat:            "text",
+		LogLevel:             "info",
+		AuditFile:            "audit.log",
+		ReportDir:            "reports",
+		QuarantineRetention:  30 * 24 * time.Hour,
+		CS: CSConfig{
+			BaseURL: "https://cs.clinicorp.tech",
+			Timeout: 30 * time.Second,
+		},
+	}
+	if profile == ProfileProd {
+		config.ProjectID = ProjectProdId
+	}
+	return config
+}
+
+// Flags são as flags globais da linha de comando, informadas antes do subcomando.
+type Flags struct {
+	set  *flag.FlagSet
+	File string
+
+	values Config
+}
+
+// NewFlags registra as flags globais no FlagSet informado.
+func NewFlags(set *flag.FlagSet) *Flags {
+	flags := &Flags{set: set}
+	set.StringVar(&flags.File, "config", "", "arquivo de configuração YAML (padrão: "+DefaultFile+" se existir)")
+	set.StringVar(&flags.values.Profile, "profile", "", "perfil da configuração: dev ou prod")
+	set.StringVar(&flags.values.ProjectID, "project", "", "projeto do Datastore")
+	set.IntVar(&flags.values.MaxTables, "max-tables", 0, "kinds deletados simultaneamente em um namespace")
+	set.IntVar(&flags.values.ConcurrentNamespaces, "concurrent-namespaces", 0, "namespaces processados simultaneamente")
+	set.IntVar(&flags.values.MaxKinds, "max-kinds", 0, "kinds deletados simultaneamente somando todos os namespaces")
+	set.IntVar(&flags.values.DeleteBatchSize, "delete-batch", 0, "chaves por DeleteMulti")
+	set.IntVar(&flags.values.CloneBatchSize, "clone-batch", 0, "entidades por PutMulti na clonagem")
+	set.IntVar(&flags.values.StorageWorkers, "storage-workers", 0, "operações simultâneas no Cloud Storage")
+	set.Float64Var(&flags.values.OpsPerSecond, "ops-per-second", 0, "limite de operações em entidades do Datastore por segundo (0 sem limite)")
+	set.StringVar(&flags.values.NamespacesFile, "namespaces", "", "arquivo com os namespaces a serem destruídos")
+	set.StringVar(&flags.values.SafeNamespacesFile, "safe-namespaces", "", "arquivo com os namespaces que nunca são destruídos")
+	set.StringVar(&flags.values.NamespacesSource, "source", "", "origem dos namespaces: arquivo, -, csv:<arquivo>:<coluna>, query:<filtro> ou cancelled[:<dias>]")
+	set.StringVar(&flags.values.LogFormat, "log-format", "", "formato dos logs: text ou json")
+	set.StringVar(&flags.values.LogLevel, "log-level", "", "nível dos logs: debug, info, warn ou error")
+	set.StringVar(&flags.values.MetricsAddr, "metrics-addr", "", "endereço do servidor de métricas Prometheus, ex: :9090")
+	set.StringVar(&flags.values.AuditFile, "audit-file", "", "arquivo local da auditoria das ações destrutivas")
+	set.StringVar(&flags.values.ReportDir, "report-dir", "", "diretório dos relatórios de execução")
+	set.BoolVar(&flags.values.Quarantine, "quarantine", false, "copia cada namespace para a quarentena antes de deletá-lo")
+	return flags
+}
+
+// apply copia para a configuração apenas as flags informadas explicitamente.
+func (f *Flags) apply(config *Config) {
+	f.set.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "profile":
+			config.Profile = f.values.Profile
+		case "project":
+			config.ProjectID = f.values.ProjectID
+		case "max-tables":
+			config.MaxTables = f.values.MaxTables
+		case "concurrent-namespaces":
+			config.ConcurrentNamespaces = f.values.ConcurrentNamespaces
+		case "max-kinds":
+			config.MaxKinds = f.values.MaxKinds
+		case "delete-batch":
+			config.DeleteBatchSize = f.values.DeleteBatchSize
+		case "clone-batch":
+			config.CloneBatchSize = f.values.CloneBatchSize
+		case "storage-workers":
+			config.StorageWorkers = f.values.StorageWorkers
+		case "ops-per-second":
+			config.OpsPerSecond = f.values.OpsPerSecond
+		case "namespaces":
+			config.NamespacesFile = f.values.NamespacesFile
+		case "safe-namespaces":
+			config.SafeNamespacesFile = f.values.SafeNamespacesFile
+		case "source":
+			config.NamespacesSource = f.values.NamespacesSource
+		case "log-format":
+			config.LogFormat = f.values.LogFormat
+		case "log-level":
+			config.LogLevel = f.values.LogLevel
+		case "metrics-addr":
+			config.MetricsAddr = f.values.MetricsAddr
+		case "audit-file":
+			config.AuditFile = f.values.AuditFile
+		case "report-dir":
+			config.ReportDir = f.values.ReportDir
+		case "quarantine":
+			config.Quarantine = f.values.Quarantine
+		}
+	})
+}
+
+// Load monta a configuração a partir dos padrões, do arquivo, das variáveis de ambiente e das flags.
+// O arquivo .env é opcional: se existir, as suas variáveis são carregadas antes das demais.
+func Load(flags *Flags) (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("erro ao carregar o arquivo .env: %v", err)
+	}
+
+	// O perfil precisa ser conhecido antes de aplicar as camadas, pois define os valores padrão
+	profile := ProfileDev
+	if value := os.Getenv("DESTRUCTOR_PROFILE"); value != "" {
+		profile = value
+	}
+	if flags != nil && flags.values.Profile != "" {
+		profile = flags.values.Profile
+	}
+	config := Defaults(profile)
+
+	file := os.Getenv("DESTRUCTOR_CONFIG")
+	if flags != nil && flags.File != "" {
+		file = flags.File
+	}
+	if err := loadFile(&config, file); err != nil {
+		return nil, err
+	}
+
+	if err := loadEnv(&config); err != nil {
+		return nil, err
+	}
+	if flags != nil {
+		flags.apply(&config)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// loadFile aplica o arquivo YAML sobre a configuração. Sem arquivo informado, o DefaultFile é
+// usado apenas se existir.
+func loadFile(config *Config, filename string) error {
+	required := filename != ""
+	if filename == "" {
+		filename = DefaultFile
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if !required && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("falha ao ler o arquivo de configuração %s: %v", filename, err)
+	}
+
+	// Decodifica sobre a configuração atual para manter os padrões dos campos ausentes
+	file := fileConfig{Config: *config}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("falha ao decodificar o arquivo de configuração %s: %v", filename, err)
+	}
+	*config = file.Config
+
+	if node, ok := file.Profiles[config.Profile]; ok {
+		// Os valores do perfil sobrescrevem os valores da raiz do arquivo
+		if err := node.Decode(config); err != nil {
+			return fmt.Errorf("falha ao decodificar o perfil %s do arquivo %s: %v", config.Profile, filename, err)
+		}
+	}
+	return nil
+}
+
+// loadEnv aplica as variáveis de ambiente sobre a configuração.
+func loadEnv(config *Config) error {
+	texts := map[string]*string{
+		"DESTRUCTOR_PROJECT":              &config.ProjectID,
+		"DESTRUCTOR_CLONE_SOURCE":         &config.CloneSourceProjectID,
+		"DESTRUCTOR_NAMESPACES_FILE":      &config.NamespacesFile,
+		"DESTRUCTOR_SAFE_NAMESPACES_FILE": &config.SafeNamespacesFile,
+		"DESTRUCTOR_NAMESPACES_SOURCE":    &config.NamespacesSource,
+		"DESTRUCTOR_LOG_FORMAT":           &config.LogFormat,
+		"DESTRUCTOR_LOG_LEVEL":            &config.LogLevel,
+		"DESTRUCTOR_METRICS_ADDR":         &config.MetricsAddr,
+		"DESTRUCTOR_AUDIT_FILE":           &config.AuditFile,
+		"DESTRUCTOR_AUDIT_KEY":            &config.AuditKey,
+		"DESTRUCTOR_AUDIT_KIND":           &config.AuditKind,
+		"DESTRUCTOR_REPORT_DIR":           &config.ReportDir,
+		"CS_BASE_URL":                     &config.CS.BaseURL,
+		"API_KEY_CS":                      &config.CS.APIKey,
+	}
+	for name, field := range texts {
+		if value := os.Getenv(name); value != "" {
+			*field = value
+		}
+	}
+
+	ints := map[string]*int{
+		"DESTRUCTOR_MAX_TABLES":            &config.MaxTables,
+		"DESTRUCTOR_CONCURRENT_NAMESPACES": &config.ConcurrentNamespaces,
+		"DESTRUCTOR_MAX_KINDS":             &config.MaxKinds,
+		"DESTRUCTOR_DELETE_BATCH":          &config.DeleteBatchSize,
+		"DESTRUCTOR_CLONE_BATCH":           &config.CloneBatchSize,
+		"DESTRUCTOR_STORAGE_WORKERS":       &config.StorageWorkers,
+	}
+	for name, field := range ints {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("variável %s inválida: %q não é um número", name, value)
+		}
+		*field = parsed
+	}
+
+	if value := os.Getenv("DESTRUCTOR_OPS_PER_SECOND"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("variável DESTRUCTOR_OPS_PER_SECOND inválida: %q não é um número", value)
+		}
+		config.OpsPerSecond = parsed
+	}
+
+	if value := os.Getenv("DESTRUCTOR_QUARANTINE"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("variável DESTRUCTOR_QUARANTINE inválida: %q não é true ou false", value)
+		}
+		config.Quarantine = parsed
+	}
+
+	if value := os.Getenv("DESTRUCTOR_QUARANTINE_RETENTION"); value != "" {
+		retention, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("variável DESTRUCTOR_QUARANTINE_RETENTION inválida: %v", err)
+		}
+		config.QuarantineRetention = retention
+	}
+
+	if value := os.Getenv("CS_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("variável CS_TIMEOUT inválida: %v", err)
+		}
+		config.CS.Timeout = timeout
+	}
+	return nil
+}
+
+// Validate verifica se os valores da configuração são válidos.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Profile != ProfileDev && c.Profile != ProfileProd {
+		errs = append(errs, fmt.Errorf("perfil %q inválido, use %s ou %s", c.Profile, ProfileDev, ProfileProd))
+	}
+	if c.ProjectID == "" {
+		errs = append(errs, fmt.Errorf("projeto do Datastore não configurado"))
+	}
+	// Sem a assinatura, quem tem acesso ao arquivo pode refazer a cadeia da auditoria
+	if (c.Profile == ProfileProd || c.ProjectID == ProjectProdId) && c.AuditKey == "" {
+		errs = append(errs, fmt.Errorf("a chave da auditoria é obrigatória em produção, defina DESTRUCTOR_AUDIT_KEY"))
+	}
+	if c.MaxTables < 1 || c.MaxTables > 64 {
+		errs = append(errs, fmt.Errorf("maxTables deve estar entre 1 e 64, recebido %d", c.MaxTables))
+	}
+	if c.ConcurrentNamespaces < 1 || c.ConcurrentNamespaces > 64 {
+		errs = append(errs, fmt.Errorf("concurrentNamespaces deve estar entre 1 e 64, recebido %d", c.ConcurrentNamespaces))
+	}
+	if c.MaxKinds < 0 || c.MaxKinds > 256 {
+		errs = append(errs, fmt.Errorf("maxKinds deve estar entre 0 e 256, recebido %d", c.MaxKinds))
+	}
+	if c.DeleteBatchSize < 1 || c.DeleteBatchSize > 1000 {
+		errs = append(errs, fmt.Errorf("deleteBatch deve estar entre 1 e 1000, recebido %d", c.DeleteBatchSize))
+	}
+	if c.CloneBatchSize < 1 || c.CloneBatchSize > 500 {
+		errs = append(errs, fmt.Errorf("cloneBatch deve estar entre 1 e 500, recebido %d", c.CloneBatchSize))
+	}
+	if c.StorageWorkers < 1 || c.StorageWorkers > 1000 {
+		errs = append(errs, fmt.Errorf("storageWorkers deve estar entre 1 e 1000, recebido %d", c.StorageWorkers))
+	}
+	if c.OpsPerSecond < 0 {
+		errs = append(errs, fmt.Errorf("opsPerSecond não pode ser negativo, recebido %g", c.OpsPerSecond))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("logFormat %q inválido, use text ou json", c.LogFormat))
+	}
+	if c.AuditFile == "" {
+		errs = append(errs, fmt.Errorf("auditFile não pode ser vazio"))
+	}
+	if c.ReportDir == "" {
+		errs = append(errs, fmt.Errorf("reportDir não pode ser vazio"))
+	}
+	if c.QuarantineRetention <= 0 {
+		errs = append(errs, fmt.Errorf("quarantineRetention deve ser positivo"))
+	}
+	if c.CS.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("timeout do CS deve ser positivo"))
+	}
+	for name, filter := range c.NamespaceFilters {
+		if filter.Empty() {
+			errs = append(errs, fmt.Errorf("o filtro %s precisa de pelo menos um critério (prefix, contains ou pattern)", name))
+		}
+		for _, pattern := range []string{filter.Pattern, filter.Exclude} {
+			if _, err := regexp.Compile(pattern); err != nil {
+				errs = append(errs, fmt.Errorf("expressão regular inválida no filtro %s: %v", name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("configuração inválida: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Hash identifica os parâmetros da execução nos registros de auditoria. As chaves secretas não
+// fazem parte do hash.
+func (c Config) Hash() string {
+	c.CS.APIKey, c.AuditKey = "", ""
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// RequireCS verifica se a configuração do CS está completa. Deve ser chamado apenas pelos comandos
+// que usam a API de assinantes.
+func (c *Config) RequireCS() error {
+	if c.CS.APIKey == "" {
+		return fmt.Errorf("API_KEY_CS não configurada (defina no .env, no ambiente ou em cs.apiKey)")
+	}
+	return nil
+}
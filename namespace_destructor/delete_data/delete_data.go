@@ -3,25 +3,39 @@ package delete_data
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"namespace_destructor/get_data"
+	"namespace_destructor/metrics"
+	"namespace_destructor/progress"
+	"namespace_destructor/ratelimit"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/datastore"
 	"google.golang.org/api/iterator"
 )
 
-const (
-	limit      = 1000
-	colorGreen = "\033[32m"
-	colorRed   = "\033[31m"
-	colorReset = "\033[0m"
-)
-
 var (
-	totalDeletions = 0
+	// totalDeletions soma os registros deletados em todos os namespaces da execução
+	totalDeletions atomic.Int64
+
+	// BatchSize é a quantidade de chaves buscadas e deletadas por DeleteMulti
+	BatchSize = 1000
+
+	// kindSlots limita as deleções de kinds simultâneas somando todos os namespaces em processamento
+	kindSlots chan struct{}
 )
 
+// SetMaxKinds define o limite global de kinds deletados ao mesmo tempo, somando todos os namespaces.
+// Deve ser chamado antes das deleções; com zero, apenas o limite por namespace (`maxTables`) é usado.
+func SetMaxKinds(maxKinds int) {
+	kindSlots = nil
+	if maxKinds > 0 {
+		kindSlots = make(chan struct{}, maxKinds)
+	}
+}
+
 // KindInfo contém informações sobre o kind e a propriedade a ser filtrada.
 type KindInfo struct {
 	Kind   string
@@ -29,17 +43,26 @@ type KindInfo struct {
 	Filter interface{}
 }
 
-// DeleteData realiza a deleção com um limite de `maxTables` tabelas simultâneas.
-func DeleteData(ctx context.Context, client *datastore.Client, kinds []KindInfo, namespace string, maxTables int) {
+// DeleteData realiza a deleção com um limite de `maxTables` tabelas simultâneas. O andamento de cada
+// kind é informado ao `tracker`, que pode ser nil. Retorna a quantidade de registros deletados por kind.
+func DeleteData(ctx context.Context, client *datastore.Client, kinds []KindInfo, namespace string, maxTables int, tracker *progress.Tracker) map[string]int64 {
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, maxTables)
-	totalDeletionsNamespace := 0
+	var totalDeletionsNamespace atomic.Int64
+	var mu sync.Mutex
+	deleted := make(map[string]int64, len(kinds))
+
+	// As estatísticas só são usadas para estimar o tempo restante, então a falha não interrompe a deleção
+	counts, err := get_data.KindEntityCounts(ctx, client, namespace)
+	if err != nil {
+		slog.Warn("Não foi possível obter a quantidade de registros dos kinds", "namespace", namespace, "error", err)
+	}
 
 	for _, kind := range kinds {
 		// Busca a propriedade de ordenação para cada kind (tabela)
 		prop, err := getPropOrdering(ctx, client, namespace, kind.Kind)
 		if err != nil {
-			fmt.Printf("Erro ao buscar propriedade para kind %s: %v\n", kind.Kind, err)
+			slog.Error("Erro ao buscar propriedade de ordenação", "namespace", namespace, "kind", kind.Kind, "error", err)
 			continue
 		}
 		kind.Prop = prop
@@ -51,23 +74,36 @@ func DeleteData(ctx context.Context, client *datastore.Client, kinds []KindInfo,
 			defer wg.Done()
 			defer func() { <-sem }() // Libera o slot ao finalizar
 
-			count := processEntities(ctx, client, kind, namespace)
-			totalDeletionsNamespace += count
-			fmt.Printf("Deleção de %d registros concluída para o kind %s no namespace %s.\n", count, kind.Kind, namespace)
+			// Aguarda também um slot global, compartilhado com os outros namespaces em processamento
+			if kindSlots != nil {
+				kindSlots <- struct{}{}
+				defer func() { <-kindSlots }()
+			}
+
+			task := tracker.StartTask(namespace, kind.Kind, "deletando", counts[kind.Kind])
+			defer task.Finish()
+
+			count := processEntities(ctx, client, kind, namespace, task)
+			totalDeletionsNamespace.Add(int64(count))
+			mu.Lock()
+			deleted[kind.Kind] = int64(count)
+			mu.Unlock()
+			slog.Info("Deleção do kind concluída", "namespace", namespace, "kind", kind.Kind, "deleted", count)
 		}(kind)
 	}
 
 	wg.Wait()
-	fmt.Printf("Processo de deleção completo para o %snamespace %s%s. Total de registros deletados: %s%d%s\n", colorGreen, namespace, colorReset, colorRed, totalDeletionsNamespace, colorReset)
+	slog.Info("Processo de deleção completo para o namespace", "namespace", namespace, "deleted", totalDeletionsNamespace.Load())
+	return deleted
 }
 
-func processEntities(ctx context.Context, client *datastore.Client, kind KindInfo, namespace string) int {
+func processEntities(ctx context.Context, client *datastore.Client, kind KindInfo, namespace string, task *progress.Task) int {
 	var totalCount int
 	var diffDeletions int
 	var cursor *datastore.Cursor
 
 	for {
-		query := datastore.NewQuery(kind.Kind).Namespace(namespace).KeysOnly().Limit(limit)
+		query := datastore.NewQuery(kind.Kind).Namespace(namespace).KeysOnly().Limit(BatchSize)
 		if kind.Prop != "" {
 			query = query.Order(kind.Prop)
 		}
@@ -76,6 +112,7 @@ func processEntities(ctx context.Context, client *datastore.Client, kind KindInf
 			query = query.Start(*cursor)
 		}
 
+		queryStart := time.Now()
 		it := client.Run(ctx, query)
 		keys := []*datastore.Key{}
 		count := 0
@@ -86,39 +123,53 @@ func processEntities(ctx context.Context, client *datastore.Client, kind KindInf
 				break
 			}
 			if err != nil {
-				log.Printf("Erro ao iterar: %v", err)
+				slog.Warn("Erro ao iterar", "namespace", namespace, "kind", kind.Kind, "error", err)
+				metrics.Retries.WithLabelValues("datastore_query").Inc()
+				ratelimit.Default.Observe(err)
 				time.Sleep(100 * time.Millisecond)
 				break
 			}
 			keys = append(keys, key)
 			count++
 		}
+		metrics.ObserveDatastore("query_keys", queryStart)
 
 		if count == 0 {
 			// Não há mais registros a serem processados
 			break
 		}
 
-		if err := client.DeleteMulti(ctx, keys); err != nil {
-			log.Printf("Falha ao deletar registros: %v", err)
+		if err := ratelimit.Default.Wait(ctx, len(keys)); err != nil {
+			slog.Error("Deleção interrompida", "namespace", namespace, "kind", kind.Kind, "error", err)
+			break
+		}
+		deleteStart := time.Now()
+		err := client.DeleteMulti(ctx, keys)
+		metrics.ObserveDatastore("delete_multi", deleteStart)
+		ratelimit.Default.Observe(err)
+		if err != nil {
+			slog.Error("Falha ao deletar registros", "namespace", namespace, "kind", kind.Kind, "keys", len(keys), "error", err)
+			metrics.BatchesFailed.WithLabelValues(metrics.Project, "delete").Inc()
 		} else {
 			totalCount += count
+			task.Add(count)
+			metrics.EntitiesDeleted.WithLabelValues(metrics.Project).Add(float64(count))
 		}
 
 		if totalCount-diffDeletions >= 10000 {
 			diffDeletions = totalCount
-			fmt.Printf("Deleção de %d registros concluída para o kind %s no namespace %s.\n", totalCount, kind.Kind, namespace)
+			slog.Debug("Deleção em andamento", "namespace", namespace, "kind", kind.Kind, "deleted", totalCount)
 		}
 
 		nextCursor, err := it.Cursor()
-		if err != nil || count < limit {
+		if err != nil || count < BatchSize {
 			// Se houve erro ao obter o cursor ou se a quantidade de registros processados for menor que o limite, assume-se que não há mais registros
 			break
 		}
 		cursor = &nextCursor
 	}
 
-	totalDeletions += totalCount
+	totalDeletions.Add(int64(totalCount))
 
 	return totalCount
 }
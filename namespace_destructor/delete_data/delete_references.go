@@ -0,0 +1,140 @@
+package delete_data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"namespace_destructor/ratelimit"
+
+	"cloud.google.com/go/datastore"
+)
+
+// KeyFilter recebe um lote de chaves logo antes de ele ser alterado e retorna as que ainda devem ser
+// alteradas, ex: as entidades que continuam com a referência quebrada. Um filtro nil mantém todas.
+type KeyFilter func(ctx context.Context, keys []*datastore.Key) ([]*datastore.Key, error)
+
+// DeleteKeys remove as entidades informadas em lotes de `BatchSize` chaves, passando cada lote por
+// `filter` antes da remoção. Com `dryRun` nada é removido. Retorna as chaves removidas.
+func DeleteKeys(ctx context.Context, client *datastore.Client, keys []*datastore.Key, filter KeyFilter, dryRun bool) ([]*datastore.Key, error) {
+	if dryRun {
+		slog.Info("[dry-run] Registros seriam removidos", keysNamespace(keys), "count", len(keys))
+		return nil, nil
+	}
+
+	var deleted []*datastore.Key
+	for start := 0; start < len(keys); start += BatchSize {
+		batch, err := filterKeys(ctx, keys[start:min(start+BatchSize, len(keys))], filter)
+		if err != nil {
+			return deleted, err
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if err := ratelimit.Default.Wait(ctx, len(batch)); err != nil {
+			return deleted, err
+		}
+		err = client.DeleteMulti(ctx, batch)
+		ratelimit.Default.Observe(err)
+		if err != nil {
+			return deleted, fmt.Errorf("falha ao deletar registros: %v", err)
+		}
+		deleted = append(deleted, batch...)
+	}
+
+	slog.Info("Deleção completa", keysNamespace(keys), "deleted", len(deleted), "skipped", len(keys)-len(deleted))
+	return deleted, nil
+}
+
+// filterKeys aplica o filtro ao lote, se houver.
+func filterKeys(ctx context.Context, batch []*datastore.Key, filter KeyFilter) ([]*datastore.Key, error) {
+	if filter == nil {
+		return batch, nil
+	}
+	kept, err := filter(ctx, batch)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao conferir os registros antes da alteração: %v", err)
+	}
+	return kept, nil
+}
+
+// QuarantineKeys move as entidades informadas para o namespace `target`, com o mesmo kind e a mesma
+// chave, passando cada lote por `filter` antes. O target é um namespace de quarentena (ver
+// quarantine.NewTarget), então as entidades seguem a mesma retenção do purge-quarantine e voltam com o
+// restore. Cada lote é copiado e removido na mesma transação, e entidades que já não existem são
+// ignoradas. Com `dryRun` nada é alterado. Retorna as chaves movidas.
+func QuarantineKeys(ctx context.Context, client *datastore.Client, keys []*datastore.Key, target string, filter KeyFilter, dryRun bool) ([]*datastore.Key, error) {
+	if dryRun {
+		slog.Info("[dry-run] Registros seriam movidos para a quarentena", keysNamespace(keys), "quarantine", target, "count", len(keys))
+		return nil, nil
+	}
+
+	// Cada entidade é uma inserção e uma remoção, e a transação aceita no máximo 500 alterações
+	const batchSize = 250
+	var moved []*datastore.Key
+	for start := 0; start < len(keys); start += batchSize {
+		batch, err := filterKeys(ctx, keys[start:min(start+batchSize, len(keys))], filter)
+		if err != nil {
+			return moved, err
+		}
+		if len(batch) == 0 {
+			continue
+		}
+
+		// Cada entidade é lida, inserida na quarentena e removida: três operações
+		if err := ratelimit.Default.Wait(ctx, 3*len(batch)); err != nil {
+			return moved, err
+		}
+		var present []*datastore.Key
+		_, err = client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			present = nil
+			entities := make([]datastore.PropertyList, len(batch))
+			err := tx.GetMulti(batch, entities)
+			multiErr, isMultiErr := err.(datastore.MultiError)
+			if err != nil && !isMultiErr {
+				return fmt.Errorf("falha ao buscar registros: %v", err)
+			}
+
+			var quarantineKeys []*datastore.Key
+			var found []datastore.PropertyList
+			for i, key := range batch {
+				if isMultiErr && multiErr[i] != nil {
+					// Removida depois da varredura, não há o que mover
+					if errors.Is(multiErr[i], datastore.ErrNoSuchEntity) {
+						continue
+					}
+					return fmt.Errorf("falha ao buscar o registro %v: %v", key, multiErr[i])
+				}
+				present = append(present, key)
+				quarantineKeys = append(quarantineKeys, &datastore.Key{Kind: key.Kind, ID: key.ID, Name: key.Name, Namespace: target})
+				found = append(found, entities[i])
+			}
+			if len(present) == 0 {
+				return nil
+			}
+			if _, err := tx.PutMulti(quarantineKeys, found); err != nil {
+				return fmt.Errorf("falha ao inserir registros na quarentena: %v", err)
+			}
+			if err := tx.DeleteMulti(present); err != nil {
+				return fmt.Errorf("falha ao deletar registros: %v", err)
+			}
+			return nil
+		})
+		ratelimit.Default.Observe(err)
+		if err != nil {
+			return moved, err
+		}
+		moved = append(moved, present...)
+	}
+
+	slog.Info("Quarentena completa", keysNamespace(keys), "quarantine", target, "moved", len(moved), "skipped", len(keys)-len(moved))
+	return moved, nil
+}
+
+// keysNamespace retorna o atributo de log com o namespace das chaves.
+func keysNamespace(keys []*datastore.Key) slog.Attr {
+	if len(keys) == 0 {
+		return slog.String("namespace", "")
+	}
+	return slog.String("namespace", keys[0].Namespace)
+}
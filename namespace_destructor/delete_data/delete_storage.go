@@ -0,0 +1,93 @@
+package delete_data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"namespace_destructor/get_data"
+	"namespace_destructor/ratelimit"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// ObjectDeletion é o resultado da remoção de arquivos do bucket.
+type ObjectDeletion struct {
+	Deleted int // arquivos removidos
+	Missing int // arquivos que já não existiam
+	Changed int // arquivos regravados depois da listagem, mantidos
+	Failed  int // arquivos que não puderam ser removidos
+}
+
+// DeleteObjects remove os arquivos informados do bucket usando `numWorkers` deleções simultâneas, limitadas
+// por ratelimit.Default. Cada arquivo só é removido na geração em que foi listado, então um arquivo
+// regravado depois da listagem é mantido. Com `dryRun` nenhum arquivo é removido, apenas listado.
+// Retorna um erro se o contexto for cancelado (ex: o lock foi perdido) ou se algum arquivo falhar.
+func DeleteObjects(ctx context.Context, client *storage.Client, bucketName string, objects []get_data.StorageObject, numWorkers int, dryRun bool) (ObjectDeletion, error) {
+	if dryRun {
+		for _, object := range objects {
+			slog.Info("[dry-run] Arquivo seria removido", "bucket", bucketName, "object", object.Name, "generation", object.Generation)
+		}
+		slog.Info("[dry-run] Arquivos seriam removidos", "bucket", bucketName, "count", len(objects))
+		return ObjectDeletion{}, nil
+	}
+
+	bucket := client.Bucket(bucketName)
+	objectCh := make(chan get_data.StorageObject, numWorkers)
+	var deleted, missing, changed, failed atomic.Int64
+	var wg sync.WaitGroup
+
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for object := range objectCh {
+				if err := ratelimit.Default.Wait(ctx, 1); err != nil {
+					continue
+				}
+				err := bucket.Object(object.Name).If(storage.Conditions{GenerationMatch: object.Generation}).Delete(ctx)
+				var apiErr *googleapi.Error
+				switch {
+				case err == nil:
+					if total := deleted.Add(1); total%1000 == 0 {
+						slog.Info("Remoção em andamento", "bucket", bucketName, "deleted", total)
+					}
+				case errors.Is(err, storage.ErrObjectNotExist):
+					missing.Add(1)
+				case errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed:
+					slog.Warn("Arquivo regravado depois da listagem, mantido", "bucket", bucketName, "object", object.Name, "generation", object.Generation)
+					changed.Add(1)
+				case ctx.Err() != nil:
+					// A remoção foi interrompida, o erro é retornado uma única vez no final
+				default:
+					slog.Error("Falha ao remover o arquivo", "bucket", bucketName, "object", object.Name, "error", err)
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+
+	// Para de distribuir arquivos assim que o contexto é cancelado, ex: o lock do namespace foi perdido
+	for _, object := range objects {
+		if ctx.Err() != nil {
+			break
+		}
+		objectCh <- object
+	}
+	close(objectCh)
+	wg.Wait()
+
+	result := ObjectDeletion{Deleted: int(deleted.Load()), Missing: int(missing.Load()), Changed: int(changed.Load()), Failed: int(failed.Load())}
+	slog.Info("Remoção completa", "bucket", bucketName, "deleted", result.Deleted, "missing", result.Missing, "changed", result.Changed, "failed", result.Failed)
+	if err := ctx.Err(); err != nil {
+		return result, fmt.Errorf("remoção dos arquivos do bucket %s interrompida: %v", bucketName, err)
+	}
+	if result.Failed > 0 {
+		return result, fmt.Errorf("%d de %d arquivos do bucket %s não foram removidos", result.Failed, len(objects), bucketName)
+	}
+	return result, nil
+}
@@ -0,0 +1,110 @@
+package delete_data
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"namespace_destructor/get_data"
+	"namespace_destructor/metrics"
+	"namespace_destructor/progress"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// VerifyPasses é a quantidade máxima de vezes que os kinds que continuam com registros depois da
+// deleção são deletados novamente.
+var VerifyPasses = 3
+
+// Verification é o resultado da verificação de um namespace depois da deleção.
+type Verification struct {
+	Passes    int              // verificações executadas
+	Kinds     []string         // kinds listados em __kind__ na última verificação
+	Remaining map[string]int64 // registros encontrados por kind na última verificação
+	Empty     bool             // __kind__ não retornou nenhum kind: o namespace está vazio
+	Unplanned []string         // kinds encontrados fora do plano, que não foram deletados
+}
+
+// DeleteAndVerify deleta os kinds e verifica se o namespace ficou vazio. A deleção conta apenas o que
+// acredita ter deletado, então a verificação lista novamente os kinds e conta os registros de cada um;
+// os kinds que ainda aparecem voltam para a deleção, até `VerifyPasses` vezes. O namespace só é
+// considerado vazio quando ListKinds não retorna nenhum kind. Retorna os registros deletados por kind,
+// somando todas as passagens.
+//
+// Com `planned`, os kinds são os de um plano aprovado: apenas eles são deletados novamente, e os kinds
+// fora do plano encontrados na verificação são mantidos e retornados como erro.
+func DeleteAndVerify(ctx context.Context, client *datastore.Client, kinds []KindInfo, namespace string, maxTables int, planned bool, tracker *progress.Tracker) (map[string]int64, Verification, error) {
+	deleted := DeleteData(ctx, client, kinds, namespace, maxTables, tracker)
+	inPlan := make(map[string]bool, len(kinds))
+	for _, kind := range kinds {
+		inPlan[kind.Kind] = true
+	}
+
+	var verification Verification
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, verification, err
+		}
+
+		listed, remaining, err := CountRemaining(ctx, client, namespace)
+		verification.Passes++
+		if err != nil {
+			return deleted, verification, err
+		}
+		verification.Kinds, verification.Remaining = listed, remaining
+		if len(listed) == 0 {
+			verification.Empty = true
+			slog.Info("Namespace verificado vazio", "namespace", namespace, "passes", verification.Passes)
+			return deleted, verification, nil
+		}
+
+		// Deleta novamente os kinds listados, inclusive os que a contagem não encontrou registros
+		var retry []KindInfo
+		verification.Unplanned = nil
+		for _, kind := range listed {
+			if planned && !inPlan[kind] {
+				verification.Unplanned = append(verification.Unplanned, kind)
+				continue
+			}
+			retry = append(retry, KindInfo{Kind: kind})
+		}
+		if len(retry) == 0 || verification.Passes > VerifyPasses {
+			if len(verification.Unplanned) > 0 {
+				slog.Error("Namespace possui kinds fora do plano aprovado, que não foram deletados", "namespace", namespace, "kinds", verification.Unplanned)
+				return deleted, verification, fmt.Errorf("o namespace %s possui os kinds %v fora do plano aprovado, que não foram deletados", namespace, verification.Unplanned)
+			}
+			slog.Error("Namespace continua com registros depois das verificações", "namespace", namespace, "remaining", remaining, "passes", verification.Passes)
+			return deleted, verification, nil
+		}
+
+		slog.Warn("Namespace ainda possui registros, deletando os kinds novamente", "namespace", namespace, "remaining", remaining, "pass", verification.Passes)
+		for kind, count := range DeleteData(ctx, client, retry, namespace, maxTables, tracker) {
+			deleted[kind] += count
+		}
+	}
+}
+
+// CountRemaining lista novamente os kinds do namespace e conta os registros de cada um com consultas
+// keys-only. Retorna os kinds listados e a quantidade de registros dos que não estão vazios.
+func CountRemaining(ctx context.Context, client *datastore.Client, namespace string) ([]string, map[string]int64, error) {
+	kinds, err := get_data.ListKinds(ctx, client, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(kinds)
+
+	remaining := make(map[string]int64)
+	for _, kind := range kinds {
+		start := time.Now()
+		count, err := client.Count(ctx, datastore.NewQuery(kind).Namespace(namespace).KeysOnly())
+		metrics.ObserveDatastore("count_keys", start)
+		if err != nil {
+			return nil, nil, fmt.Errorf("falha ao contar os registros do kind %s no namespace %s: %v", kind, namespace, err)
+		}
+		if count > 0 {
+			remaining[kind] = int64(count)
+		}
+	}
+	return kinds, remaining, nil
+}
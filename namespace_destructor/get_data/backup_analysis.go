@@ -0,0 +1,251 @@
+package get_data
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"namespace_destructor/namespace_list"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Situações possíveis do tenant "vivo" de um grupo de backups.
+const (
+	TenantActive   = "ativo"
+	TenantInactive = "inativo" // o namespace existe, mas não possui nenhuma tabela
+	TenantOrphaned = "orfao"   // o namespace não existe mais
+)
+
+// backupPattern separa o nome do tenant, o sufixo de backup e o restante do namespace.
+// Exemplos: "1234_backup2.br.mt.sorriso", "abc_emergency_backup.br.rj.macae", "zclonez.backup.br.sc.jaragua_do_sul".
+var backupPattern = regexp.MustCompile(`^([^.]+?)(_emergency_backup|_backup(\d*)|\.backup)((?:\.[^.]+)*)$`)
+
+// BackupNamespace contém as informações de um namespace de backup.
+type BackupNamespace struct {
+	Namespace  string
+	Generation int // 1 para "_backup", N para "_backupN" e 0 para "_emergency_backup"
+	Emergency  bool
+	StorageGB  float64
+	StatsAt    time.Time // timestamp de __Stat_Ns_Total__, usado como a idade do backup
+	Rank       int       // 1 é o backup mais recente do tenant
+	Keep       bool
+}
+
+// BackupGroup agrupa os backups de um mesmo tenant.
+type BackupGroup struct {
+	LiveNamespace string
+	TenantStatus  string
+	Backups       []BackupNamespace
+}
+
+// ParseBackupNamespace retorna o namespace vivo e a geração de um namespace de backup.
+func ParseBackupNamespace(namespace string) (live string, generation int, emergency bool, ok bool) {
+	match := backupPattern.FindStringSubmatch(namespace)
+	if match == nil {
+		return "", 0, false, false
+	}
+
+	live = match[1] + match[4]
+	switch {
+	case match[2] == "_emergency_backup":
+		return live, 0, true, true
+	case match[3] != "":
+		generation, _ = strconv.Atoi(match[3])
+		return live, generation, false, true
+	default:
+		return live, 1, false, true
+	}
+}
+
+// AnalyzeBackupNamespaces agrupa os namespaces de backup listados em um arquivo com o seu tenant vivo,
+// identifica backups órfãos ou de tenants inativos e gera um plano de retenção mantendo os `keep`
+// backups mais recentes de cada tenant, além dos backups de emergência. O relatório é salvo em `reportFile` e os namespaces a serem
+// removidos em `planFile`, no mesmo formato do namespaces.txt.
+func AnalyzeBackupNamespaces(ctx context.Context, client *datastore.Client, backupFile string, keep int, reportFile, planFile string) ([]BackupGroup, error) {
+	backups, err := namespace_list.Read(backupFile)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Listando namespaces vivos")
+	namespaces, err := fetchNamespaces(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		existing[namespace] = true
+	}
+
+	// Agrupa os backups pelo namespace vivo
+	groupsByLive := make(map[string]*BackupGroup)
+	for _, namespace := range backups {
+		live, generation, emergency, ok := ParseBackupNamespace(namespace)
+		if !ok {
+			slog.Warn("Namespace não segue o padrão de backup e será ignorado", "namespace", namespace)
+			continue
+		}
+		group, found := groupsByLive[live]
+		if !found {
+			group = &BackupGroup{LiveNamespace: live}
+			groupsByLive[live] = group
+		}
+		group.Backups = append(group.Backups, BackupNamespace{Namespace: namespace, Generation: generation, Emergency: emergency})
+	}
+
+	var groups []*BackupGroup
+	for _, group := range groupsByLive {
+		groups = append(groups, group)
+	}
+
+	// Calcula o tamanho dos backups e a situação dos tenants em paralelo
+	var wg sync.WaitGroup
+	groupCh := make(chan *BackupGroup, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range groupCh {
+				group.TenantStatus = tenantStatus(ctx, client, group.LiveNamespace, existing)
+				for i := range group.Backups {
+					storageGB, statsAt, err := namespaceStats(ctx, client, group.Backups[i].Namespace)
+					group.Backups[i].StatsAt = statsAt
+					if err != nil {
+						slog.Error("Erro ao calcular o armazenamento", "namespace", group.Backups[i].Namespace, "error", err)
+						continue
+					}
+					group.Backups[i].StorageGB = storageGB
+				}
+			}
+		}()
+	}
+	for _, group := range groups {
+		groupCh <- group
+	}
+	close(groupCh)
+	wg.Wait()
+
+	result := make([]BackupGroup, 0, len(groups))
+	for _, group := range groups {
+		rankBackups(group.Backups, keep)
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LiveNamespace < result[j].LiveNamespace
+	})
+
+	if err := writeBackupReport(reportFile, result); err != nil {
+		return nil, err
+	}
+	if err := writeRetentionPlan(planFile, result); err != nil {
+		return nil, err
+	}
+
+	slog.Info("Relatório e plano de retenção salvos", "report", reportFile, "plan", planFile)
+	return result, nil
+}
+
+// tenantStatus verifica se o namespace vivo ainda existe e se possui tabelas.
+func tenantStatus(ctx context.Context, client *datastore.Client, live string, existing map[string]bool) string {
+	if !existing[live] {
+		return TenantOrphaned
+	}
+	kinds, err := fetchKinds(ctx, client, live)
+	if err != nil {
+		slog.Error("Erro ao listar kinds", "namespace", live, "error", err)
+		return TenantActive
+	}
+	if len(kinds) == 0 {
+		return TenantInactive
+	}
+	return TenantActive
+}
+
+// rankBackups ordena os backups do mais recente para o mais antigo pelo timestamp das estatísticas, que
+// reflete a idade real do backup; a geração do nome e o tamanho só desempatam. Backups sem estatísticas
+// ficam por último. Os `keep` primeiros são mantidos, e os backups de emergência são sempre mantidos,
+// sem ocupar as vagas dos demais.
+func rankBackups(backups []BackupNamespace, keep int) {
+	sort.SliceStable(backups, func(i, j int) bool {
+		if !backups[i].StatsAt.Equal(backups[j].StatsAt) {
+			return backups[i].StatsAt.After(backups[j].StatsAt)
+		}
+		if backups[i].Generation != backups[j].Generation {
+			return backups[i].Generation > backups[j].Generation
+		}
+		return backups[i].StorageGB > backups[j].StorageGB
+	})
+	kept := 0
+	for i := range backups {
+		backups[i].Rank = i + 1
+		switch {
+		case backups[i].Emergency:
+			backups[i].Keep = true
+		case kept < keep:
+			backups[i].Keep = true
+			kept++
+		}
+	}
+}
+
+func writeBackupReport(filename string, groups []BackupGroup) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("falha ao criar arquivo: %v", err)
+	}
+	defer file.Close()
+
+	var totalGB, removableGB float64
+	writer := bufio.NewWriter(file)
+	for _, group := range groups {
+		fmt.Fprintf(writer, "%s [%s]\n", group.LiveNamespace, group.TenantStatus)
+		for _, backup := range group.Backups {
+			action := "remover"
+			if backup.Keep {
+				action = "manter"
+			}
+			statsAt := "sem estatísticas"
+			if !backup.StatsAt.IsZero() {
+				statsAt = backup.StatsAt.Format(time.DateOnly)
+			}
+			fmt.Fprintf(writer, "  %d. %s - %s - %.2f GB - %s\n", backup.Rank, backup.Namespace, statsAt, backup.StorageGB, action)
+			totalGB += backup.StorageGB
+			if !backup.Keep {
+				removableGB += backup.StorageGB
+			}
+		}
+	}
+	fmt.Fprintf(writer, "Total: %.2f GB - Removível: %.2f GB\n", totalGB, removableGB)
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("falha ao escrever no arquivo: %v", err)
+	}
+	return nil
+}
+
+func writeRetentionPlan(filename string, groups []BackupGroup) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("falha ao criar arquivo: %v", err)
+	}
+	defer file.Close()
+
+	for _, group := range groups {
+		for _, backup := range group.Backups {
+			if backup.Keep {
+				continue
+			}
+			if _, err := file.WriteString(backup.Namespace + "\n"); err != nil {
+				return fmt.Errorf("falha ao escrever no arquivo: %v", err)
+			}
+		}
+	}
+	return nil
+}
@@ -4,11 +4,13 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"log/slog"
+	"namespace_destructor/namespace_list"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/datastore"
 	"cloud.google.com/go/storage"
@@ -45,7 +47,7 @@ func fetchNamespaces(ctx context.Context, client *datastore.Client) ([]string, e
 		}
 
 		count += len(batchNamespaces)
-		fmt.Printf("Namespaces listados: %d\n", count)
+		slog.Debug("Namespaces listados", "count", count)
 
 		if len(batchNamespaces) == 0 {
 			// Se não há mais registros no batch atual, finaliza a busca
@@ -66,18 +68,42 @@ func fetchNamespaces(ctx context.Context, client *datastore.Client) ([]string, e
 		cursor = &nextCursor
 	}
 
-	fmt.Printf("Total de namespaces listados: %d\n", count)
+	slog.Info("Total de namespaces listados", "count", count)
+	return namespaces, nil
+}
+
+// ListNamespacesWithPrefix lista os namespaces que começam com `prefix`. Com um prefixo, apenas o
+// intervalo de chaves correspondente de __namespace__ é consultado, sem percorrer todo o projeto.
+func ListNamespacesWithPrefix(ctx context.Context, client *datastore.Client, prefix string) ([]string, error) {
+	if prefix == "" {
+		return fetchNamespaces(ctx, client)
+	}
+
+	// Os nomes de namespaces são ASCII, então qualquer nome com o prefixo é menor que prefixo + U+FFFF
+	query := datastore.NewQuery("__namespace__").KeysOnly().
+		FilterField("__key__", ">=", datastore.NameKey("__namespace__", prefix, nil)).
+		FilterField("__key__", "<", datastore.NameKey("__namespace__", prefix+"\uffff", nil))
+	keys, err := client.GetAll(ctx, query, nil)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao listar namespaces com o prefixo %s: %v", prefix, err)
+	}
+
+	namespaces := make([]string, 0, len(keys))
+	for _, key := range keys {
+		namespaces = append(namespaces, key.Name)
+	}
+	slog.Info("Namespaces listados", "prefix", prefix, "count", len(namespaces))
 	return namespaces, nil
 }
 
 // ListKinds lista todos os kinds em um namespace especificado, chamando a função fetchKinds.
 func ListKinds(ctx context.Context, client *datastore.Client, namespace string) ([]string, error) {
-	fmt.Printf("Listando kinds no namespace: %s...\n", namespace)
+	slog.Debug("Listando kinds", "namespace", namespace)
 	kinds, err := fetchKinds(ctx, client, namespace)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Printf("Kinds listados no namespace %s: %v\n", namespace, kinds)
+	slog.Info("Kinds listados", "namespace", namespace, "kinds", kinds)
 	return kinds, nil
 }
 
@@ -108,43 +134,33 @@ func fetchKinds(ctx context.Context, client *datastore.Client, namespace string)
 
 // ListNamespaces lista todos os namespaces no Datastore e salva em um arquivo "todos.txt".
 func ListNamespaces(ctx context.Context, client *datastore.Client) error {
-	fmt.Println("Listando namespaces...")
+	slog.Info("Listando namespaces")
 	namespaces, err := fetchNamespaces(ctx, client)
 	if err != nil {
 		return err
 	}
 
-	// Abre o arquivo para escrita
-	fmt.Println("Salvando namespaces em 'todos.txt'...")
-	file, err := os.Create("todos.txt")
-	if err != nil {
-		return fmt.Errorf("falha ao criar arquivo: %v", err)
-	}
-	defer file.Close()
-
-	// Escreve cada namespace em uma linha do arquivo
-	for _, namespace := range namespaces {
-		_, err := file.WriteString(namespace + "\n")
-		if err != nil {
-			return fmt.Errorf("falha ao escrever no arquivo: %v", err)
-		}
+	// Grava a lista de forma atômica, um namespace por linha
+	slog.Info("Salvando namespaces", "file", "todos.txt")
+	if err := namespace_list.Write("todos.txt", namespaces); err != nil {
+		return err
 	}
 
-	fmt.Println("Namespaces salvos em 'todos.txt'")
+	slog.Info("Namespaces salvos", "file", "todos.txt", "count", len(namespaces))
 	return nil
 }
 
 // ListBackupNamespaces lista todos os namespaces que contêm a palavra "backup" em seu nome
 // e salva em um arquivo "backup.txt".
 func ListBackupNamespaces(ctx context.Context, client *datastore.Client) error {
-	fmt.Println("Listando namespaces com 'backup'...")
+	slog.Info("Listando namespaces com 'backup'")
 	namespaces, err := fetchNamespaces(ctx, client)
 	if err != nil {
 		return err
 	}
 
 	var backupNamespaces []string
-	fmt.Println("Salvando namespaces com 'backup' em 'backup.txt'...")
+	slog.Info("Salvando namespaces com 'backup'", "file", "backup.txt")
 	for _, namespace := range namespaces {
 		// Adiciona apenas namespaces que contenham "backup" em seu nome
 		if strings.Contains(strings.ToLower(namespace), "backup") {
@@ -152,22 +168,12 @@ func ListBackupNamespaces(ctx context.Context, client *datastore.Client) error {
 		}
 	}
 
-	// Abre o arquivo para escrita
-	file, err := os.Create("backup.txt")
-	if err != nil {
-		return fmt.Errorf("falha ao criar arquivo: %v", err)
-	}
-	defer file.Close()
-
-	// Escreve cada namespace em uma linha do arquivo
-	for _, namespace := range backupNamespaces {
-		_, err := file.WriteString(namespace + "\n")
-		if err != nil {
-			return fmt.Errorf("falha ao escrever no arquivo: %v", err)
-		}
+	// Grava a lista de forma atômica, um namespace por linha
+	if err := namespace_list.Write("backup.txt", backupNamespaces); err != nil {
+		return err
 	}
 
-	fmt.Println("Namespaces com 'backup' salvos em 'backup.txt'")
+	slog.Info("Namespaces com 'backup' salvos", "file", "backup.txt", "count", len(backupNamespaces))
 	return nil
 }
 
@@ -204,10 +210,10 @@ func CalculateTotalStorageFromFile(ctx context.Context, client *datastore.Client
 			for namespace := range namespaceCh {
 				storageGB, err := calculateNamespaceStorage(ctx, client, namespace)
 				if err != nil {
-					fmt.Printf("Erro ao calcular o armazenamento do namespace %s: %v\n", namespace, err)
+					slog.Error("Erro ao calcular o armazenamento", "namespace", namespace, "error", err)
 					continue
 				}
-				fmt.Printf("Namespace: %s - Armazenamento: %.2f GB\n", namespace, storageGB)
+				slog.Info("Armazenamento do namespace", "namespace", namespace, "gb", fmt.Sprintf("%.2f", storageGB))
 				resultCh <- storageGB
 			}
 		}()
@@ -225,43 +231,85 @@ func CalculateTotalStorageFromFile(ctx context.Context, client *datastore.Client
 		totalStorageGB += storage
 	}
 
-	fmt.Printf("Armazenamento total de todos os namespaces: %.2f GB\n", totalStorageGB)
+	slog.Info("Armazenamento total de todos os namespaces", "gb", fmt.Sprintf("%.2f", totalStorageGB))
 	return nil
 }
 
 // calculateNamespaceStorage calcula o armazenamento em GBs de um namespace usando __Stat_Ns_Total__,
 // incluindo o armazenamento de índices.
 func calculateNamespaceStorage(ctx context.Context, client *datastore.Client, namespace string) (float64, error) {
+	storageGB, _, err := namespaceStats(ctx, client, namespace)
+	return storageGB, err
+}
+
+// namespaceStats retorna o armazenamento em GBs e o horário (timestamp) das estatísticas de
+// __Stat_Ns_Total__ do namespace.
+func namespaceStats(ctx context.Context, client *datastore.Client, namespace string) (float64, time.Time, error) {
 	query := datastore.NewQuery("__Stat_Ns_Total__").Namespace(namespace).Limit(1)
 	var stats []datastore.PropertyList
 
 	_, err := client.GetAll(ctx, query, &stats)
 	if err != nil {
-		return 0, fmt.Errorf("falha ao consultar __Stat_Ns_Total__ para o namespace %s: %v", namespace, err)
+		return 0, time.Time{}, fmt.Errorf("falha ao consultar __Stat_Ns_Total__ para o namespace %s: %v", namespace, err)
 	}
 
 	if len(stats) == 0 {
-		return 0, fmt.Errorf("nenhuma estatística encontrada para o namespace %s", namespace)
+		return 0, time.Time{}, fmt.Errorf("nenhuma estatística encontrada para o namespace %s", namespace)
 	}
 
 	// Extrai os campos necessários diretamente
 	var totalBytes int64
+	var timestamp time.Time
 	for _, prop := range stats[0] {
 		switch prop.Name {
 		case "entity_bytes", "builtin_index_bytes", "composite_index_bytes":
 			if val, ok := prop.Value.(int64); ok {
 				totalBytes += val
 			}
+		case "timestamp":
+			if val, ok := prop.Value.(time.Time); ok {
+				timestamp = val
+			}
 		}
 	}
 
 	if totalBytes == 0 {
-		return 0, fmt.Errorf("não foi possível encontrar os campos de armazenamento para o namespace %s", namespace)
+		return 0, timestamp, fmt.Errorf("não foi possível encontrar os campos de armazenamento para o namespace %s", namespace)
 	}
 
 	// Converte bytes para gigabytes (1 GB = 1,073,741,824 bytes)
 	storageGB := float64(totalBytes) / (1024 * 1024 * 1024)
-	return storageGB, nil
+	return storageGB, timestamp, nil
+}
+
+// KindEntityCounts retorna a quantidade de registros de cada kind do namespace segundo __Stat_Ns_Kind__.
+// As estatísticas são atualizadas periodicamente pelo Datastore, então os valores são aproximados.
+func KindEntityCounts(ctx context.Context, client *datastore.Client, namespace string) (map[string]int64, error) {
+	query := datastore.NewQuery("__Stat_Ns_Kind__").Namespace(namespace)
+	var stats []datastore.PropertyList
+
+	_, err := client.GetAll(ctx, query, &stats)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar __Stat_Ns_Kind__ para o namespace %s: %v", namespace, err)
+	}
+
+	counts := make(map[string]int64, len(stats))
+	for _, stat := range stats {
+		var kind string
+		var count int64
+		for _, prop := range stat {
+			switch prop.Name {
+			case "kind_name":
+				kind, _ = prop.Value.(string)
+			case "count":
+				count, _ = prop.Value.(int64)
+			}
+		}
+		if kind != "" {
+			counts[kind] = count
+		}
+	}
+	return counts, nil
 }
 
 func GetSubscriberBucketName(ctx context.Context, client *datastore.Client, namespace string) (string, error) {
@@ -272,12 +320,10 @@ func GetSubscriberBucketName(ctx context.Context, client *datastore.Client, name
 
 	_, err := client.GetAll(ctx, query, &results)
 	if err != nil {
-		fmt.Printf("Erro ao buscar SubscriberBucketName: %v\n", err)
 		return "", fmt.Errorf("falha ao buscar SubscriberBucketName: %v", err)
 	}
 
 	if len(results) == 0 {
-		fmt.Printf("Nenhum registro encontrado para o namespace %s\n", namespace)
 		return "", fmt.Errorf("nenhum registro encontrado para o namespace %s", namespace)
 	}
 
@@ -294,13 +340,8 @@ func GetSubscriberBucketName(ctx context.Context, client *datastore.Client, name
 }
 
 // GetFileCreationDateFromBucket busca a data de criação de um arquivo dentro do bucket no Google Cloud Storage.
-func GetFileCreationDateFromBucket(ctx context.Context, bucketName, fileName string) (string, error) {
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return "", fmt.Errorf("falha ao criar o cliente do Google Cloud Storage: %v", err)
-	}
-	defer client.Close()
-
+// O cliente do Storage é recebido como parâmetro para ser reaproveitado entre as chamadas.
+func GetFileCreationDateFromBucket(ctx context.Context, client *storage.Client, bucketName, fileName string) (string, error) {
 	bucket := client.Bucket(bucketName)
 	object := bucket.Object(fileName)
 	attrs, err := object.Attrs(ctx)
@@ -313,210 +354,77 @@ func GetFileCreationDateFromBucket(ctx context.Context, bucketName, fileName str
 	return creationDate, nil
 }
 
+// GetPersonName busca a propriedade Name de uma entidade do kind Person.
 func GetPersonName(ctx context.Context, client *datastore.Client, namespace string, id string) (string, error) {
+	return GetEntityProperty(ctx, client, namespace, "Person", id, "Name")
+}
+
+// EntityKey monta a chave de uma entidade a partir de um id textual, usando um id numérico quando
+// possível e o id como nome da chave caso contrário.
+func EntityKey(namespace, kind, id string) *datastore.Key {
 	var key *datastore.Key
 
 	// Tenta converter o ID para int64
 	if intID, err := strconv.ParseInt(id, 10, 64); err == nil {
 		// Se a conversão for bem-sucedida, use int64
-		key = datastore.IDKey("Person", intID, nil)
+		key = datastore.IDKey(kind, intID, nil)
 	} else {
 		// Se a conversão falhar, use a string como chave
-		key = datastore.NameKey("Person", id, nil)
+		key = datastore.NameKey(kind, id, nil)
 	}
 
 	key.Namespace = namespace
+	return key
+}
+
+// EntityKeys retorna as chaves em que uma entidade com o id textual pode estar gravada. Um id numérico
+// pode ser tanto um id quanto o nome da chave, então as duas chaves são retornadas, com o id primeiro.
+func EntityKeys(namespace, kind, id string) []*datastore.Key {
+	nameKey := datastore.NameKey(kind, id, nil)
+	nameKey.Namespace = namespace
+	if intID, err := strconv.ParseInt(id, 10, 64); err == nil && intID > 0 {
+		idKey := datastore.IDKey(kind, intID, nil)
+		idKey.Namespace = namespace
+		return []*datastore.Key{idKey, nameKey}
+	}
+	return []*datastore.Key{nameKey}
+}
+
+// GetEntityProperty busca o valor textual de uma propriedade da entidade `kind` com o id informado.
+// Se a entidade não existir, o erro retornado envolve datastore.ErrNoSuchEntity.
+func GetEntityProperty(ctx context.Context, client *datastore.Client, namespace, kind, id, property string) (string, error) {
+	key := EntityKey(namespace, kind, id)
 
-	query := datastore.NewQuery("Person").Namespace(namespace).FilterField("__key__", "=", key).Limit(1)
+	query := datastore.NewQuery(kind).Namespace(namespace).FilterField("__key__", "=", key).Limit(1)
 
 	var results []datastore.PropertyList
 
 	_, err := client.GetAll(ctx, query, &results)
 	if err != nil {
-		return "", fmt.Errorf("falha ao buscar o registro do kind 'Person' com id %s: %v", id, err)
+		return "", fmt.Errorf("falha ao buscar o registro do kind '%s' com id %s: %v", kind, id, err)
 	}
 
 	if len(results) == 0 {
-		return "", fmt.Errorf("nenhum registro encontrado para o id %s no kind 'Person'", id)
+		return "", fmt.Errorf("nenhum registro encontrado para o id %s no kind '%s': %w", id, kind, datastore.ErrNoSuchEntity)
 	}
 
 	for _, prop := range results[0] {
-		if prop.Name == "Name" {
-			if name, ok := prop.Value.(string); ok {
-				return name, nil
+		if prop.Name == property {
+			switch val := prop.Value.(type) {
+			case string:
+				return val, nil
+			case int64:
+				return strconv.FormatInt(val, 10), nil
 			}
 		}
 	}
 
-	return "", fmt.Errorf("campo Name não encontrado")
+	return "", fmt.Errorf("campo %s não encontrado", property)
 }
 
-// CheckImagesFromThumb verifica imagens que possuem ImageViewBox com altura e largura de 250 e salva as informações em um arquivo.
+// CheckImagesFromThumb verifica imagens que possuem ImageViewBox com altura e largura de 250 e salva as
+// informações em um arquivo CSV na pasta `check_thumb_images`.
 func CheckImagesFromThumb(ctx context.Context, client *datastore.Client, namespace string) error {
-	bucketName, err := GetSubscriberBucketName(ctx, client, namespace)
-	count := 0
-	countDiff := 0
-	if err != nil {
-		return err
-	}
-
-	fmt.Printf("BucketName: %s\n", bucketName)
-
-	query := datastore.NewQuery("Picture").Namespace(namespace).KeysOnly()
-	var records []string
-	var mu sync.Mutex // Protege o acesso à variável compartilhada `records`
-
-	// Define a quantidade de goroutines e um canal para gerenciar os resultados
-	const numWorkers = 10
-	resultsCh := make(chan []string, numWorkers)
-
-	var wg sync.WaitGroup
-
-	// Função de processamento para cada worker
-	processBatch := func(batch []*datastore.Key) {
-		defer wg.Done()
-		localRecords := []string{}
-
-		for _, key := range batch {
-			var entity datastore.PropertyList
-			if err := client.Get(ctx, key, &entity); err != nil {
-				fmt.Printf("Erro ao buscar a entidade: %v\n", err)
-				continue
-			}
-
-			var fileName, kind, kindId string
-
-			count++
-			if count-countDiff >= 100 {
-				fmt.Printf("Total de imagens %d listadas: %d\n", count, len(records))
-				countDiff = count
-			}
-			for _, prop := range entity {
-				if prop.Name == "ImageViewBox" {
-					if nestedEntity, ok := prop.Value.(*datastore.Entity); ok {
-						viewBoxMap := make(map[string]interface{})
-						for _, nestedProp := range nestedEntity.Properties {
-							viewBoxMap[nestedProp.Name] = nestedProp.Value
-						}
-
-						// Verifica se height e width são iguais a 250
-						if viewBoxMap["height"] == int64(250) && viewBoxMap["width"] == int64(250) {
-							for _, prop := range entity {
-								if prop.Name == "Kind" {
-									if val, ok := prop.Value.(string); ok {
-										kind = val
-									}
-								} else if prop.Name == "KindId" {
-									if val, ok := prop.Value.(int64); ok {
-										kindId = strconv.FormatInt(val, 10)
-									}
-								} else if prop.Name == "FileName" {
-									if val, ok := prop.Value.(string); ok {
-										fileName = val
-									}
-								}
-							}
-
-							dateInserted, err := GetFileCreationDateFromBucket(ctx, bucketName, fileName)
-							if err != nil {
-								fmt.Printf("Erro ao obter a data de criação para o arquivo %s: %v\n", fileName, err)
-								continue
-							}
-
-							keyString := key.Name
-							if keyString == "" {
-								keyString = strconv.FormatInt(key.ID, 10)
-							}
-
-							record := fmt.Sprintf("%s-%s-%s-%s", dateInserted, keyString, kind, kindId)
-
-							// Se o kind for "Person", busca a propriedade Name e adiciona ao registro
-							if kind == "Person" && kindId != "" {
-								name, err := GetPersonName(ctx, client, namespace, kindId)
-								if err != nil {
-									fmt.Printf("Erro ao obter o Name do kind 'Person' com id %s: %v\n", kindId, err)
-									continue
-								}
-								record = fmt.Sprintf("%s-%s", record, name)
-							}
-
-							localRecords = append(localRecords, record)
-						}
-					}
-				}
-			}
-		}
-
-		// Envia os registros processados para o canal
-		resultsCh <- localRecords
-	}
-
-	// Executa a busca em lotes de chaves e distribui para as goroutines
-	it := client.Run(ctx, query)
-	for {
-		var batch []*datastore.Key
-		for i := 0; i < 100; i++ {
-			key, err := it.Next(nil)
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				return fmt.Errorf("falha ao iterar sobre chaves: %v", err)
-			}
-			batch = append(batch, key)
-		}
-
-		if len(batch) == 0 {
-			break
-		}
-
-		wg.Add(1)
-		go processBatch(batch)
-	}
-
-	// Aguarda o término de todas as goroutines
-	go func() {
-		wg.Wait()
-		close(resultsCh)
-	}()
-
-	// Coleta os resultados das goroutines
-	for res := range resultsCh {
-		mu.Lock()
-		records = append(records, res...)
-		mu.Unlock()
-	}
-
-	// Ordena os registros pela data no início da string
-	sort.Slice(records, func(i, j int) bool {
-		return records[i] < records[j]
-	})
-
-	// Cria a pasta `check_thumb_images` se não existir
-	if _, err := os.Stat("check_thumb_images"); os.IsNotExist(err) {
-		err = os.Mkdir("check_thumb_images", os.ModePerm)
-		if err != nil {
-			return fmt.Errorf("falha ao criar a pasta 'check_thumb_images': %v", err)
-		}
-	}
-
-	// Define o caminho do arquivo com base no namespace
-	filePath := fmt.Sprintf("check_thumb_images/%s.txt", namespace)
-
-	// Escreve os registros ordenados em um arquivo
-	file, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("falha ao criar o arquivo %s: %v", filePath, err)
-	}
-	defer file.Close()
-
-	for _, record := range records {
-		if _, err := file.WriteString(record + "\n"); err != nil {
-			return fmt.Errorf("falha ao escrever no arquivo %s: %v", filePath, err)
-		}
-	}
-
-	fmt.Printf("Total de imagens %d listadas: %d\n", count, len(records))
-	fmt.Printf("Arquivo salvo em: %s\n", filePath)
-	return nil
+	_, err := RunPictureAudit(ctx, client, namespace, DefaultThumbAudit(), "check_thumb_images")
+	return err
 }
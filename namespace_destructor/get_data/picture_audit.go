@@ -0,0 +1,531 @@
+package get_data
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"namespace_destructor/lock"
+	"namespace_destructor/metrics"
+	"namespace_destructor/ratelimit"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Colunas disponíveis para a saída da auditoria, além das colunas definidas nos joins.
+const (
+	ColumnDate     = "date" // data de criação do arquivo no bucket (YYYYMMDD)
+	ColumnKey      = "key"
+	ColumnKind     = "kind"
+	ColumnKindId   = "kindId"
+	ColumnFileName = "fileName"
+	ColumnWidth    = "width"
+	ColumnHeight   = "height"
+)
+
+// Formatos de saída da auditoria.
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// PictureAudit descreve uma auditoria de imagens: quais entidades são selecionadas, quais dados
+// relacionados são buscados e quais colunas são gravadas no arquivo de saída.
+type PictureAudit struct {
+	Kind    string      `json:"kind"`
+	Match   AuditMatch  `json:"match"`
+	Joins   []AuditJoin `json:"joins"`
+	Columns []string    `json:"columns"`
+	Format  string      `json:"format"`
+}
+
+// AuditMatch define as condições para uma entidade entrar no resultado. Todas as condições
+// configuradas precisam ser verdadeiras; as não configuradas são ignoradas.
+type AuditMatch struct {
+	Width           *AuditRange `json:"width,omitempty"`
+	Height          *AuditRange `json:"height,omitempty"`
+	MissingFileName bool        `json:"missingFileName,omitempty"`
+	MissingObject   bool        `json:"missingObject,omitempty"`
+}
+
+// AuditRange é um intervalo fechado de valores. Max igual a zero significa sem limite superior.
+type AuditRange struct {
+	Min int64 `json:"min"`
+	Max int64 `json:"max"`
+}
+
+// AuditJoin busca a propriedade `Property` da entidade referenciada por Kind/KindId quando o
+// Kind da imagem for igual a `Kind`, gravando o valor na coluna `As`.
+type AuditJoin struct {
+	Kind     string `json:"kind"`
+	Property string `json:"property"`
+	As       string `json:"as"`
+}
+
+// AuditRecord é uma linha do resultado da auditoria, indexada pelo nome da coluna.
+type AuditRecord map[string]string
+
+// DefaultThumbAudit reproduz a verificação original de thumbnails: imagens 250x250 com o nome da pessoa.
+func DefaultThumbAudit() PictureAudit {
+	return PictureAudit{
+		Kind: "Picture",
+		Match: AuditMatch{
+			Width:  &AuditRange{Min: 250, Max: 250},
+			Height: &AuditRange{Min: 250, Max: 250},
+		},
+		Joins:   []AuditJoin{{Kind: "Person", Property: "Name", As: "name"}},
+		Columns: []string{ColumnDate, ColumnKey, ColumnKind, ColumnKindId, "name"},
+		Format:  FormatCSV,
+	}
+}
+
+// LoadPictureAudit carrega as regras de uma auditoria de um arquivo JSON, completando os campos
+// não informados com os valores de DefaultThumbAudit.
+func LoadPictureAudit(filename string) (PictureAudit, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return PictureAudit{}, fmt.Errorf("falha ao ler o arquivo %s: %v", filename, err)
+	}
+
+	var audit PictureAudit
+	if err := json.Unmarshal(data, &audit); err != nil {
+		return PictureAudit{}, fmt.Errorf("falha ao decodificar o arquivo %s: %v", filename, err)
+	}
+
+	defaults := DefaultThumbAudit()
+	if audit.Kind == "" {
+		audit.Kind = defaults.Kind
+	}
+	if len(audit.Columns) == 0 {
+		audit.Columns = []string{ColumnDate, ColumnKey, ColumnKind, ColumnKindId}
+		for _, join := range audit.Joins {
+			audit.Columns = append(audit.Columns, join.As)
+		}
+	}
+	if audit.Format == "" {
+		audit.Format = defaults.Format
+	}
+	if audit.Format != FormatCSV && audit.Format != FormatJSON {
+		return PictureAudit{}, fmt.Errorf("formato %q inválido, use %q ou %q", audit.Format, FormatCSV, FormatJSON)
+	}
+	return audit, nil
+}
+
+// needsBucket indica se a auditoria precisa consultar o bucket do assinante.
+func (a PictureAudit) needsBucket() bool {
+	if a.Match.MissingObject {
+		return true
+	}
+	for _, column := range a.Columns {
+		if column == ColumnDate {
+			return true
+		}
+	}
+	return false
+}
+
+// pictureInfo contém os campos de uma imagem usados pelas regras da auditoria.
+type pictureInfo struct {
+	fileName  string
+	kind      string
+	kindId    string
+	width     int64
+	height    int64
+	hasWidth  bool
+	hasHeight bool
+}
+
+func newPictureInfo(entity datastore.PropertyList) pictureInfo {
+	var info pictureInfo
+	for _, prop := range entity {
+		switch prop.Name {
+		case "Kind":
+			if val, ok := prop.Value.(string); ok {
+				info.kind = val
+			}
+		case "KindId":
+			switch val := prop.Value.(type) {
+			case int64:
+				info.kindId = strconv.FormatInt(val, 10)
+			case string:
+				info.kindId = val
+			}
+		case "FileName":
+			if val, ok := prop.Value.(string); ok {
+				info.fileName = val
+			}
+		case "ImageViewBox":
+			if nestedEntity, ok := prop.Value.(*datastore.Entity); ok {
+				for _, nestedProp := range nestedEntity.Properties {
+					switch nestedProp.Name {
+					case "width":
+						info.width, info.hasWidth = toInt64(nestedProp.Value)
+					case "height":
+						info.height, info.hasHeight = toInt64(nestedProp.Value)
+					}
+				}
+			}
+		}
+	}
+	return info
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch val := value.(type) {
+	case int64:
+		return val, true
+	case float64:
+		return int64(val), true
+	}
+	return 0, false
+}
+
+func (r *AuditRange) contains(value int64, ok bool) bool {
+	if r == nil {
+		return true
+	}
+	if !ok {
+		return false
+	}
+	return value >= r.Min && (r.Max == 0 || value <= r.Max)
+}
+
+// matchesEntity verifica as condições que não dependem do bucket.
+func (m AuditMatch) matchesEntity(info pictureInfo) bool {
+	if !m.Width.contains(info.width, info.hasWidth) || !m.Height.contains(info.height, info.hasHeight) {
+		return false
+	}
+	if m.MissingFileName && info.fileName != "" {
+		return false
+	}
+	return true
+}
+
+func keyString(key *datastore.Key) string {
+	if key.Name != "" {
+		return key.Name
+	}
+	return strconv.FormatInt(key.ID, 10)
+}
+
+// RunPictureAudit executa a auditoria no namespace e grava o resultado em `outputDir/<namespace>.<formato>`,
+// retornando o caminho do arquivo gerado. Se alguma entidade não puder ser verificada, o arquivo é gravado
+// com o que foi auditado e um erro informa quantas entidades falharam, para que o resultado parcial não
+// seja confundido com uma auditoria completa.
+func RunPictureAudit(ctx context.Context, client *datastore.Client, namespace string, audit PictureAudit, outputDir string) (string, error) {
+	// Evita que a varredura concorra com a deleção ou a clonagem do mesmo namespace
+	lease, ctx, err := lock.Acquire(ctx, client, namespace, lock.OperationScan)
+	if err != nil {
+		return "", err
+	}
+	defer lease.Release()
+
+	var bucketName string
+	var bucket *storage.BucketHandle
+	if audit.needsBucket() {
+		var err error
+		bucketName, err = GetSubscriberBucketName(ctx, client, namespace)
+		if err != nil {
+			return "", err
+		}
+		slog.Info("Bucket do assinante encontrado", "namespace", namespace, "bucket", bucketName)
+
+		storageClient, err := storage.NewClient(ctx)
+		if err != nil {
+			return "", fmt.Errorf("falha ao criar o cliente do Google Cloud Storage: %v", err)
+		}
+		defer storageClient.Close()
+		bucket = storageClient.Bucket(bucketName)
+	}
+
+	// Os workers compartilham os clientes, o cache das entidades relacionadas e os contadores
+	joins := newJoinCache()
+	var count, matched, failed atomic.Int64
+	var records []AuditRecord
+	var mu sync.Mutex // Protege o acesso à variável compartilhada `records`
+
+	// Processa cada lote de chaves com um único GetMulti
+	processBatch := func(batch []*datastore.Key) {
+		entities, err := getMultiWithRetry(ctx, client, batch)
+		multiErr, isMultiErr := err.(datastore.MultiError)
+		if err != nil && !isMultiErr {
+			slog.Error("Erro ao buscar as entidades", "namespace", namespace, "kind", audit.Kind, "keys", len(batch), "error", err)
+			failed.Add(int64(len(batch)))
+			return
+		}
+
+		localRecords := []AuditRecord{}
+		for i, key := range batch {
+			if isMultiErr && multiErr[i] != nil {
+				// Uma entidade deletada depois da listagem das chaves não precisa ser auditada
+				if errors.Is(multiErr[i], datastore.ErrNoSuchEntity) {
+					continue
+				}
+				slog.Error("Erro ao buscar a entidade", "namespace", namespace, "kind", audit.Kind, "key", keyString(key), "error", multiErr[i])
+				failed.Add(1)
+				continue
+			}
+
+			if total := count.Add(1); total%1000 == 0 {
+				slog.Info("Auditoria em andamento", "namespace", namespace, "kind", audit.Kind, "scanned", total, "matched", matched.Load())
+			}
+
+			info := newPictureInfo(entities[i])
+			if !audit.Match.matchesEntity(info) {
+				continue
+			}
+
+			record := AuditRecord{
+				ColumnKey:      keyString(key),
+				ColumnKind:     info.kind,
+				ColumnKindId:   info.kindId,
+				ColumnFileName: info.fileName,
+			}
+			if info.hasWidth {
+				record[ColumnWidth] = strconv.FormatInt(info.width, 10)
+			}
+			if info.hasHeight {
+				record[ColumnHeight] = strconv.FormatInt(info.height, 10)
+			}
+
+			if bucket != nil && (info.fileName != "" || audit.Match.MissingObject) {
+				attrs, err := objectAttrs(ctx, bucket, info.fileName)
+				if audit.Match.MissingObject {
+					// Só entram no resultado as imagens cujo arquivo não existe no bucket
+					if !errors.Is(err, storage.ErrObjectNotExist) {
+						continue
+					}
+				} else if err != nil {
+					slog.Error("Erro ao obter a data de criação do arquivo", "namespace", namespace, "object", info.fileName, "error", err)
+					failed.Add(1)
+					continue
+				}
+				if attrs != nil {
+					record[ColumnDate] = attrs.Created.Format("20060102")
+				}
+			}
+
+			// Busca as propriedades das entidades relacionadas
+			for _, join := range audit.Joins {
+				if info.kind != join.Kind || info.kindId == "" {
+					continue
+				}
+				value, err := joins.get(ctx, client, namespace, join, info.kindId)
+				if err != nil {
+					if !errors.Is(err, datastore.ErrNoSuchEntity) {
+						failed.Add(1)
+					}
+					slog.Warn("Erro ao buscar a entidade relacionada", "namespace", namespace, "kind", join.Kind, "id", info.kindId, "property", join.Property, "error", err)
+					continue
+				}
+				record[join.As] = value
+			}
+
+			matched.Add(1)
+			localRecords = append(localRecords, record)
+		}
+
+		mu.Lock()
+		records = append(records, localRecords...)
+		mu.Unlock()
+	}
+
+	// Inicia um número fixo de workers que consomem os lotes de chaves
+	const numWorkers = 10
+	const batchSize = 100
+	batchCh := make(chan []*datastore.Key, numWorkers)
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batchCh {
+				processBatch(batch)
+			}
+		}()
+	}
+
+	// Executa a busca das chaves e distribui os lotes para os workers
+	iterErr := func() error {
+		defer close(batchCh)
+		it := client.Run(ctx, datastore.NewQuery(audit.Kind).Namespace(namespace).KeysOnly())
+		batch := make([]*datastore.Key, 0, batchSize)
+		for {
+			key, err := it.Next(nil)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("falha ao iterar sobre chaves: %v", err)
+			}
+			batch = append(batch, key)
+			if len(batch) == batchSize {
+				batchCh <- batch
+				batch = make([]*datastore.Key, 0, batchSize)
+			}
+		}
+		if len(batch) > 0 {
+			batchCh <- batch
+		}
+		return nil
+	}()
+
+	// Aguarda o término de todos os workers
+	wg.Wait()
+	if iterErr != nil {
+		return "", iterErr
+	}
+
+	filePath, err := writeAuditRecords(outputDir, namespace, audit, records)
+	if err != nil {
+		return "", err
+	}
+
+	if failed.Load() > 0 {
+		slog.Error("Auditoria incompleta", "namespace", namespace, "kind", audit.Kind, "scanned", count.Load(), "matched", len(records), "failed", failed.Load(), "file", filePath)
+		return filePath, fmt.Errorf("auditoria do namespace %s incompleta: %d entidades não foram verificadas, veja o log", namespace, failed.Load())
+	}
+	slog.Info("Auditoria completa", "namespace", namespace, "kind", audit.Kind, "scanned", count.Load(), "matched", len(records), "file", filePath)
+	return filePath, nil
+}
+
+// getMultiWithRetry busca as entidades do lote com um único GetMulti, tentando novamente os erros
+// temporários do Datastore. Um MultiError sem erros temporários é retornado para ser tratado por chave.
+func getMultiWithRetry(ctx context.Context, client *datastore.Client, keys []*datastore.Key) ([]datastore.PropertyList, error) {
+	const maxAttempts = 3
+	for attempt := 1; ; attempt++ {
+		if err := ratelimit.Default.Wait(ctx, len(keys)); err != nil {
+			return nil, err
+		}
+		entities := make([]datastore.PropertyList, len(keys))
+		err := client.GetMulti(ctx, keys, entities)
+		ratelimit.Default.Observe(err)
+		if err == nil || attempt == maxAttempts || !ratelimit.IsTransient(err) {
+			return entities, err
+		}
+
+		metrics.Retries.WithLabelValues("datastore_get").Inc()
+		slog.Warn("Erro temporário ao buscar as entidades, tentando novamente", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+}
+
+// objectAttrs busca os atributos de um arquivo no bucket, retornando storage.ErrObjectNotExist
+// também quando a imagem não possui FileName.
+func objectAttrs(ctx context.Context, bucket *storage.BucketHandle, fileName string) (*storage.ObjectAttrs, error) {
+	if fileName == "" {
+		return nil, storage.ErrObjectNotExist
+	}
+	attrs, err := bucket.Object(fileName).Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao obter atributos do arquivo %s: %w", fileName, err)
+	}
+	return attrs, nil
+}
+
+// writeAuditRecords ordena os registros pelas colunas configuradas e os grava no formato da auditoria.
+func writeAuditRecords(outputDir, namespace string, audit PictureAudit, records []AuditRecord) (string, error) {
+	sort.Slice(records, func(i, j int) bool {
+		for _, column := range audit.Columns {
+			if records[i][column] != records[j][column] {
+				return records[i][column] < records[j][column]
+			}
+		}
+		return false
+	})
+
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("falha ao criar a pasta '%s': %v", outputDir, err)
+	}
+
+	filePath := filepath.Join(outputDir, fmt.Sprintf("%s.%s", namespace, audit.Format))
+	file, err := os.Create(filePath)
+	if err != nil {
+		return "", fmt.Errorf("falha ao criar o arquivo %s: %v", filePath, err)
+	}
+	defer file.Close()
+
+	switch audit.Format {
+	case FormatJSON:
+		rows := make([]map[string]string, 0, len(records))
+		for _, record := range records {
+			row := make(map[string]string, len(audit.Columns))
+			for _, column := range audit.Columns {
+				row[column] = record[column]
+			}
+			rows = append(rows, row)
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(rows); err != nil {
+			return "", fmt.Errorf("falha ao escrever no arquivo %s: %v", filePath, err)
+		}
+	default:
+		writer := csv.NewWriter(file)
+		writer.Write(audit.Columns)
+		for _, record := range records {
+			row := make([]string, len(audit.Columns))
+			for i, column := range audit.Columns {
+				row[i] = record[column]
+			}
+			writer.Write(row)
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return "", fmt.Errorf("falha ao escrever no arquivo %s: %v", filePath, err)
+		}
+	}
+
+	return filePath, nil
+}
+
+// joinCache guarda os valores já buscados das entidades relacionadas, já que muitas imagens
+// apontam para a mesma entidade (ex: várias fotos de uma mesma pessoa). Apenas os valores encontrados e
+// as entidades inexistentes são guardados; outros erros, como timeouts, são buscados novamente.
+type joinCache struct {
+	mu     sync.Mutex
+	values map[string]joinResult
+}
+
+type joinResult struct {
+	value string
+	err   error
+}
+
+func newJoinCache() *joinCache {
+	return &joinCache{values: make(map[string]joinResult)}
+}
+
+func (c *joinCache) get(ctx context.Context, client *datastore.Client, namespace string, join AuditJoin, id string) (string, error) {
+	cacheKey := join.Kind + "/" + id + "/" + join.Property
+
+	c.mu.Lock()
+	result, ok := c.values[cacheKey]
+	c.mu.Unlock()
+	if ok {
+		return result.value, result.err
+	}
+
+	value, err := GetEntityProperty(ctx, client, namespace, join.Kind, id, join.Property)
+	if err == nil || errors.Is(err, datastore.ErrNoSuchEntity) {
+		c.mu.Lock()
+		c.values[cacheKey] = joinResult{value: value, err: err}
+		c.mu.Unlock()
+	}
+	return value, err
+}
@@ -0,0 +1,252 @@
+package get_data
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"namespace_destructor/ratelimit"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// Relation descreve uma referência entre kinds no estilo Kind + KindId: as entidades de `Kind` guardam
+// o kind de destino em `KindProperty` e o id da entidade de destino em `IdProperty`. Quando
+// `KindProperty` é vazio, o kind de destino é sempre `TargetKind`.
+type Relation struct {
+	Kind         string
+	KindProperty string
+	IdProperty   string
+	TargetKind   string
+}
+
+func (r Relation) String() string {
+	if r.KindProperty == "" {
+		return fmt.Sprintf("%s.%s -> %s", r.Kind, r.IdProperty, r.TargetKind)
+	}
+	return fmt.Sprintf("%s.%s/%s", r.Kind, r.KindProperty, r.IdProperty)
+}
+
+// ParseRelations converte relações no formato "kind:kindProperty:idProperty" ou
+// "kind::idProperty:targetKind", separadas por vírgula. Ex: "Picture:Kind:KindId".
+func ParseRelations(value string) ([]Relation, error) {
+	var relations []Relation
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) < 3 || len(parts) > 4 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("relação %q inválida, use kind:kindProperty:idProperty", item)
+		}
+		relation := Relation{Kind: parts[0], KindProperty: parts[1], IdProperty: parts[2]}
+		if len(parts) == 4 {
+			relation.TargetKind = parts[3]
+		}
+		if relation.KindProperty == "" && relation.TargetKind == "" {
+			return nil, fmt.Errorf("relação %q sem kind de destino", item)
+		}
+		relations = append(relations, relation)
+	}
+	return relations, nil
+}
+
+// DanglingReference é uma entidade que aponta para uma entidade que não existe mais.
+type DanglingReference struct {
+	Relation   Relation
+	SourceKey  *datastore.Key
+	TargetKind string
+	TargetId   string
+	TargetKeys []*datastore.Key // chaves possíveis do destino, usadas por StillDangling
+}
+
+// pendingReference é uma referência que ainda precisa ter a existência do destino verificada.
+type pendingReference struct {
+	sourceKey  *datastore.Key
+	targetKind string
+	targetKeys []*datastore.Key // chaves possíveis do destino, ver EntityKeys
+	targetId   string
+}
+
+// FindDanglingReferences percorre as entidades de cada relação no namespace e retorna as referências
+// cujo destino não existe. A existência dos destinos é verificada em lotes com GetMulti.
+func FindDanglingReferences(ctx context.Context, client *datastore.Client, namespace string, relations []Relation) ([]DanglingReference, error) {
+	const batchSize = 500
+	var dangling []DanglingReference
+
+	for _, relation := range relations {
+		slog.Info("Verificando relação", "namespace", namespace, "kind", relation.Kind, "relation", relation.String())
+		exists := make(map[string]bool) // cache da existência dos destinos já verificados
+		var pending []pendingReference
+		count := 0
+
+		flush := func() error {
+			found, err := checkKeysExist(ctx, client, pending, exists)
+			if err != nil {
+				return err
+			}
+			for i, ref := range pending {
+				if !found[i] {
+					dangling = append(dangling, DanglingReference{Relation: relation, SourceKey: ref.sourceKey, TargetKind: ref.targetKind, TargetId: ref.targetId, TargetKeys: ref.targetKeys})
+				}
+			}
+			pending = pending[:0]
+			return nil
+		}
+
+		it := client.Run(ctx, datastore.NewQuery(relation.Kind).Namespace(namespace))
+		for {
+			var entity datastore.PropertyList
+			key, err := it.Next(&entity)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("falha ao iterar registros do kind %s: %v", relation.Kind, err)
+			}
+			count++
+
+			targetKind, targetId := relation.TargetKind, ""
+			for _, prop := range entity {
+				switch prop.Name {
+				case relation.KindProperty:
+					if val, ok := prop.Value.(string); ok {
+						targetKind = val
+					}
+				case relation.IdProperty:
+					switch val := prop.Value.(type) {
+					case int64:
+						targetId = strconv.FormatInt(val, 10)
+					case string:
+						targetId = val
+					}
+				}
+			}
+			if targetKind == "" || targetId == "" || targetId == "0" {
+				continue
+			}
+
+			pending = append(pending, pendingReference{sourceKey: key, targetKind: targetKind, targetKeys: EntityKeys(namespace, targetKind, targetId), targetId: targetId})
+			if len(pending) == batchSize {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if len(pending) > 0 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		slog.Info("Relação verificada", "namespace", namespace, "kind", relation.Kind, "relation", relation.String(), "scanned", count)
+	}
+
+	slog.Info("Verificação de referências completa", "namespace", namespace, "dangling", len(dangling))
+	return dangling, nil
+}
+
+// StillDangling verifica novamente os destinos das referências e retorna as que continuam quebradas,
+// ex: antes de agir sobre referências encontradas por uma varredura anterior. O cache não é usado,
+// pois o destino pode ter sido criado depois da varredura.
+func StillDangling(ctx context.Context, client *datastore.Client, refs []DanglingReference) ([]DanglingReference, error) {
+	const batchSize = 500
+	var dangling []DanglingReference
+	for start := 0; start < len(refs); start += batchSize {
+		batch := refs[start:min(start+batchSize, len(refs))]
+		pending := make([]pendingReference, len(batch))
+		for i, ref := range batch {
+			pending[i] = pendingReference{sourceKey: ref.SourceKey, targetKind: ref.TargetKind, targetKeys: ref.TargetKeys, targetId: ref.TargetId}
+		}
+		found, err := checkKeysExist(ctx, client, pending, make(map[string]bool))
+		if err != nil {
+			return nil, err
+		}
+		for i, ref := range batch {
+			if !found[i] {
+				dangling = append(dangling, ref)
+			}
+		}
+	}
+	return dangling, nil
+}
+
+// checkKeysExist verifica quais destinos existem, consultando apenas as chaves que não estão no cache.
+// Um destino existe se qualquer uma das suas chaves possíveis existir.
+func checkKeysExist(ctx context.Context, client *datastore.Client, refs []pendingReference, exists map[string]bool) ([]bool, error) {
+	var keys []*datastore.Key
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		for _, key := range ref.targetKeys {
+			encoded := key.String()
+			if _, cached := exists[encoded]; cached || seen[encoded] {
+				continue
+			}
+			seen[encoded] = true
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) > 0 {
+		if err := ratelimit.Default.Wait(ctx, len(keys)); err != nil {
+			return nil, err
+		}
+		entities := make([]datastore.PropertyList, len(keys))
+		err := client.GetMulti(ctx, keys, entities)
+		ratelimit.Default.Observe(err)
+		multiErr, isMultiErr := err.(datastore.MultiError)
+		if err != nil && !isMultiErr {
+			return nil, fmt.Errorf("falha ao buscar as entidades de destino: %v", err)
+		}
+		for i, key := range keys {
+			if isMultiErr && multiErr[i] != nil {
+				if multiErr[i] != datastore.ErrNoSuchEntity {
+					return nil, fmt.Errorf("falha ao buscar a entidade %s: %v", key, multiErr[i])
+				}
+				exists[key.String()] = false
+				continue
+			}
+			exists[key.String()] = true
+		}
+	}
+
+	found := make([]bool, len(refs))
+	for i, ref := range refs {
+		for _, key := range ref.targetKeys {
+			found[i] = found[i] || exists[key.String()]
+		}
+	}
+	return found, nil
+}
+
+// WriteDanglingReferences grava as referências quebradas em `outputDir/<namespace>.csv`.
+func WriteDanglingReferences(outputDir, namespace string, dangling []DanglingReference) (string, error) {
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("falha ao criar a pasta '%s': %v", outputDir, err)
+	}
+
+	filePath := filepath.Join(outputDir, namespace+".csv")
+	file, err := os.Create(filePath)
+	if err != nil {
+		return "", fmt.Errorf("falha ao criar o arquivo %s: %v", filePath, err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"relation", "kind", "key", "targetKind", "targetId"})
+	for _, ref := range dangling {
+		writer.Write([]string{ref.Relation.String(), ref.SourceKey.Kind, keyString(ref.SourceKey), ref.TargetKind, ref.TargetId})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", fmt.Errorf("falha ao escrever no arquivo %s: %v", filePath, err)
+	}
+
+	slog.Info("Relatório salvo", "namespace", namespace, "file", filePath)
+	return filePath, nil
+}
@@ -0,0 +1,190 @@
+package get_data
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Tipos de divergência encontrados na reconciliação entre o Datastore e o bucket.
+const (
+	IssueMissingObject = "arquivo_ausente"   // a imagem aponta para um arquivo que não existe no bucket
+	IssueOrphanObject  = "arquivo_orfao"     // o arquivo existe no bucket, mas nenhuma imagem aponta para ele
+	IssueRecentObject  = "arquivo_recente"   // sem imagem, mas criado há menos de minAge; não é considerado órfão
+	IssueMetadata      = "metadados_errados" // o arquivo existe, mas está vazio ou não é uma imagem
+)
+
+// StorageIssue é uma divergência encontrada entre uma imagem e o bucket.
+type StorageIssue struct {
+	Type       string
+	PictureKey string
+	Object     string
+	Size       int64
+	Detail     string
+}
+
+// StorageObject é um arquivo do bucket na geração em que foi listado. A geração permite remover o
+// arquivo apenas se ele não tiver sido regravado depois da listagem.
+type StorageObject struct {
+	Name       string
+	Generation int64
+	Size       int64
+}
+
+// StorageReconciliation é o resultado da reconciliação de um namespace.
+type StorageReconciliation struct {
+	Namespace       string
+	Bucket          string
+	Pictures        int
+	Objects         int
+	Issues          []StorageIssue
+	OrphanObjects   []StorageObject
+	OrphanSizeBytes int64
+	RecentObjects   int // arquivos sem imagem ignorados por serem mais novos que minAge
+}
+
+// ReconcilePictureStorage compara o FileName das entidades `kind` do namespace com os arquivos do bucket
+// do assinante, listando arquivos ausentes, arquivos órfãos e arquivos com metadados divergentes.
+// Apenas os arquivos com o prefixo `prefix` são considerados no bucket. Arquivos sem imagem criados há
+// menos de `minAge` antes do início da reconciliação não são órfãos, pois a imagem pode ter sido gravada
+// depois da leitura do Datastore.
+func ReconcilePictureStorage(ctx context.Context, client *datastore.Client, storageClient *storage.Client, namespace, kind, prefix string, minAge time.Duration) (*StorageReconciliation, error) {
+	cutoff := time.Now().Add(-minAge)
+	bucketName, err := GetSubscriberBucketName(ctx, client, namespace)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Bucket do assinante encontrado", "namespace", namespace, "bucket", bucketName)
+
+	result := &StorageReconciliation{Namespace: namespace, Bucket: bucketName}
+
+	// Lista as imagens e os arquivos referenciados por elas
+	slog.Info("Listando registros", "namespace", namespace, "kind", kind)
+	referenced := make(map[string][]string) // FileName -> chaves das imagens, um arquivo pode ser usado por várias
+	it := client.Run(ctx, datastore.NewQuery(kind).Namespace(namespace))
+	for {
+		var entity datastore.PropertyList
+		key, err := it.Next(&entity)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("falha ao iterar registros do kind %s: %v", kind, err)
+		}
+		result.Pictures++
+
+		info := newPictureInfo(entity)
+		if info.fileName == "" {
+			continue
+		}
+		referenced[info.fileName] = append(referenced[info.fileName], keyString(key))
+	}
+
+	// Lista os arquivos do bucket, verificando os metadados dos que estão referenciados
+	slog.Info("Listando arquivos do bucket", "namespace", namespace, "bucket", bucketName)
+	found := make(map[string]bool, len(referenced))
+	objects := storageClient.Bucket(bucketName).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("falha ao listar arquivos do bucket %s: %v", bucketName, err)
+		}
+		result.Objects++
+
+		pictureKeys, ok := referenced[attrs.Name]
+		if !ok && attrs.Created.After(cutoff) {
+			result.RecentObjects++
+			result.Issues = append(result.Issues, StorageIssue{Type: IssueRecentObject, Object: attrs.Name, Size: attrs.Size, Detail: "criado em " + attrs.Created.Format(time.RFC3339)})
+			continue
+		}
+		if !ok {
+			result.OrphanObjects = append(result.OrphanObjects, StorageObject{Name: attrs.Name, Generation: attrs.Generation, Size: attrs.Size})
+			result.OrphanSizeBytes += attrs.Size
+			result.Issues = append(result.Issues, StorageIssue{Type: IssueOrphanObject, Object: attrs.Name, Size: attrs.Size})
+			continue
+		}
+		found[attrs.Name] = true
+
+		if detail := objectMetadataIssue(attrs); detail != "" {
+			for _, pictureKey := range pictureKeys {
+				result.Issues = append(result.Issues, StorageIssue{Type: IssueMetadata, PictureKey: pictureKey, Object: attrs.Name, Size: attrs.Size, Detail: detail})
+			}
+		}
+	}
+
+	for fileName, pictureKeys := range referenced {
+		// Arquivos fora do prefixo não foram listados e não podem ser considerados ausentes
+		if found[fileName] || !strings.HasPrefix(fileName, prefix) {
+			continue
+		}
+		for _, pictureKey := range pictureKeys {
+			result.Issues = append(result.Issues, StorageIssue{Type: IssueMissingObject, PictureKey: pictureKey, Object: fileName})
+		}
+	}
+
+	sort.Slice(result.Issues, func(i, j int) bool {
+		if result.Issues[i].Type != result.Issues[j].Type {
+			return result.Issues[i].Type < result.Issues[j].Type
+		}
+		if result.Issues[i].Object != result.Issues[j].Object {
+			return result.Issues[i].Object < result.Issues[j].Object
+		}
+		return result.Issues[i].PictureKey < result.Issues[j].PictureKey
+	})
+
+	slog.Info("Reconciliação completa", "namespace", namespace, "bucket", bucketName, "pictures", result.Pictures, "objects", result.Objects,
+		"issues", len(result.Issues), "orphans", len(result.OrphanObjects), "orphanBytes", result.OrphanSizeBytes, "recent", result.RecentObjects)
+	return result, nil
+}
+
+// objectMetadataIssue retorna a descrição do problema nos metadados de um arquivo referenciado por uma imagem.
+func objectMetadataIssue(attrs *storage.ObjectAttrs) string {
+	if attrs.Size == 0 {
+		return "arquivo vazio"
+	}
+	if attrs.ContentType != "" && !strings.HasPrefix(attrs.ContentType, "image/") {
+		return "content-type " + attrs.ContentType
+	}
+	return ""
+}
+
+// WriteStorageReconciliation grava as divergências em `outputDir/<namespace>.csv`.
+func WriteStorageReconciliation(outputDir string, result *StorageReconciliation) (string, error) {
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("falha ao criar a pasta '%s': %v", outputDir, err)
+	}
+
+	filePath := filepath.Join(outputDir, result.Namespace+".csv")
+	file, err := os.Create(filePath)
+	if err != nil {
+		return "", fmt.Errorf("falha ao criar o arquivo %s: %v", filePath, err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"type", "pictureKey", "object", "size", "detail"})
+	for _, issue := range result.Issues {
+		writer.Write([]string{issue.Type, issue.PictureKey, issue.Object, strconv.FormatInt(issue.Size, 10), issue.Detail})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", fmt.Errorf("falha ao escrever no arquivo %s: %v", filePath, err)
+	}
+
+	slog.Info("Relatório salvo", "namespace", result.Namespace, "file", filePath)
+	return filePath, nil
+}
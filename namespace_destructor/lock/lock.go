@@ -0,0 +1,264 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"namespace_destructor/audit"
+	"namespace_destructor/logger"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// Kind é o kind dos locks, gravados no namespace padrão do projeto.
+const Kind = "DestructorLock"
+
+// Operações que travam um namespace.
+const (
+	OperationDelete = "delete"
+	OperationClone  = "clone"
+	OperationScan   = "scan"
+)
+
+var (
+	// TTL é a validade do lock sem heartbeat. Um lock expirado pode ser tomado por outro processo.
+	TTL = 2 * time.Minute
+
+	// HeartbeatInterval é o intervalo de renovação do lock enquanto o processo trabalha.
+	HeartbeatInterval = 30 * time.Second
+)
+
+// Lock é o registro do lock de um namespace.
+type Lock struct {
+	Namespace   string
+	Operation   string
+	Owner       string
+	AcquiredAt  time.Time
+	HeartbeatAt time.Time
+	ExpiresAt   time.Time
+}
+
+// Expired indica se o lock passou da validade, ou seja, o dono parou de renová-lo.
+func (l Lock) Expired() bool {
+	return time.Now().After(l.ExpiresAt)
+}
+
+// LockedError indica que o namespace está travado por outro processo.
+type LockedError struct {
+	Lock Lock
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("namespace %s travado por %s (%s) até %s", e.Lock.Namespace, e.Lock.Owner, e.Lock.Operation, e.Lock.ExpiresAt.Format(time.RFC3339))
+}
+
+// Owner identifica este processo nos locks: operador, máquina e execução. É calculado no primeiro uso,
+// depois que o .env já foi carregado.
+var Owner = sync.OnceValue(func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s/%s", audit.Operator(), host, logger.RunID)
+})
+
+// lockKey retorna a chave do lock. O prefixo evita nomes vazios e nomes reservados do Datastore (__x__).
+func lockKey(namespace string) *datastore.Key {
+	return datastore.NameKey(Kind, "namespace:"+namespace, nil)
+}
+
+// Lease é um lock obtido por este processo, renovado periodicamente até o Release.
+type Lease struct {
+	client    *datastore.Client
+	namespace string
+	cancel    context.CancelFunc
+	stop      chan struct{}
+	done      chan struct{}
+	once      sync.Once
+}
+
+// Acquire trava o namespace para a operação. Se o lock de outro processo ainda estiver válido,
+// retorna um *LockedError. O contexto retornado é cancelado se o lock for perdido: outro processo o
+// tomou, ou o heartbeat falhou por tempo suficiente para que ele possa ter expirado. Assim o trabalho é
+// interrompido antes que outro processo possa tomar o lock.
+func Acquire(ctx context.Context, client *datastore.Client, namespace, operation string) (*Lease, context.Context, error) {
+	key := lockKey(namespace)
+	requestedAt := time.Now()
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var current Lock
+		err := tx.Get(key, &current)
+		if err != nil && !errors.Is(err, datastore.ErrNoSuchEntity) {
+			return err
+		}
+		if err == nil && current.Owner != Owner() && !current.Expired() {
+			return &LockedError{Lock: current}
+		}
+
+		now := time.Now().UTC()
+		_, err = tx.Put(key, &Lock{Namespace: namespace, Operation: operation, Owner: Owner(), AcquiredAt: now, HeartbeatAt: now, ExpiresAt: now.Add(TTL)})
+		return err
+	})
+	if err != nil {
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			return nil, nil, locked
+		}
+		return nil, nil, fmt.Errorf("falha ao travar o namespace %s: %v", namespace, err)
+	}
+
+	leaseCtx, cancel := context.WithCancel(ctx)
+	lease := &Lease{client: client, namespace: namespace, cancel: cancel, stop: make(chan struct{}), done: make(chan struct{})}
+	go lease.heartbeat(leaseCtx, requestedAt, lease.renew)
+
+	slog.Debug("Namespace travado", "namespace", namespace, "operation", operation, "owner", Owner())
+	return lease, leaseCtx, nil
+}
+
+// heartbeat renova o lock com `renew` até o Release. Se o lock deixar de ser deste processo, ou se as
+// renovações falharem por TTL menos HeartbeatInterval desde `renewedAt` (o início da última renovação
+// bem-sucedida), cancela o contexto: a margem garante que o trabalho pare antes de o lock expirar.
+func (l *Lease) heartbeat(ctx context.Context, renewedAt time.Time, renew func(ctx context.Context) error) {
+	defer close(l.done)
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		attempt := time.Now()
+		err := renew(ctx)
+		var locked *LockedError
+		switch {
+		case errors.As(err, &locked):
+			slog.Error("Lock perdido, interrompendo o trabalho no namespace", "namespace", l.namespace, "owner", locked.Lock.Owner)
+			l.cancel()
+			return
+		case err != nil && time.Since(renewedAt) >= TTL-HeartbeatInterval:
+			slog.Error("Lock não renovado antes de expirar, interrompendo o trabalho no namespace", "namespace", l.namespace, "renewedAt", renewedAt, "error", err)
+			l.cancel()
+			return
+		case err != nil:
+			// O lock continua válido até expirar, então a falha é tentada novamente no próximo ciclo
+			slog.Warn("Falha ao renovar o lock", "namespace", l.namespace, "error", err)
+		default:
+			renewedAt = attempt
+		}
+	}
+}
+
+func (l *Lease) renew(ctx context.Context) error {
+	key := lockKey(l.namespace)
+	_, err := l.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var current Lock
+		if err := tx.Get(key, &current); err != nil {
+			if errors.Is(err, datastore.ErrNoSuchEntity) {
+				return &LockedError{Lock: Lock{Namespace: l.namespace, Owner: "(removido)"}}
+			}
+			return err
+		}
+		if current.Owner != Owner() {
+			return &LockedError{Lock: current}
+		}
+		current.HeartbeatAt = time.Now().UTC()
+		current.ExpiresAt = current.HeartbeatAt.Add(TTL)
+		_, err := tx.Put(key, &current)
+		return err
+	})
+	return err
+}
+
+// Release interrompe o heartbeat e remove o lock, se ainda for deste processo.
+func (l *Lease) Release() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		close(l.stop)
+		<-l.done
+		defer l.cancel()
+
+		// Usa um contexto próprio para liberar o lock mesmo que o contexto do trabalho tenha sido cancelado
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		key := lockKey(l.namespace)
+		_, err := l.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			var current Lock
+			if err := tx.Get(key, &current); err != nil {
+				if errors.Is(err, datastore.ErrNoSuchEntity) {
+					return nil
+				}
+				return err
+			}
+			if current.Owner != Owner() {
+				return nil
+			}
+			return tx.Delete(key)
+		})
+		if err != nil {
+			slog.Error("Falha ao liberar o lock", "namespace", l.namespace, "error", err)
+		}
+	})
+}
+
+// List retorna todos os locks do projeto.
+func List(ctx context.Context, client *datastore.Client) ([]Lock, error) {
+	var locks []Lock
+	it := client.Run(ctx, datastore.NewQuery(Kind))
+	for {
+		var current Lock
+		_, err := it.Next(&current)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("falha ao listar os locks: %v", err)
+		}
+		locks = append(locks, current)
+	}
+	return locks, nil
+}
+
+// Get retorna o lock atual do namespace.
+func Get(ctx context.Context, client *datastore.Client, namespace string) (Lock, error) {
+	var current Lock
+	if err := client.Get(ctx, lockKey(namespace), &current); err != nil {
+		if errors.Is(err, datastore.ErrNoSuchEntity) {
+			return Lock{}, fmt.Errorf("o namespace %s não está travado", namespace)
+		}
+		return Lock{}, fmt.Errorf("falha ao buscar o lock do namespace %s: %v", namespace, err)
+	}
+	return current, nil
+}
+
+// Break remove o lock do namespace e retorna o lock removido. Sem `force`, apenas locks expirados são
+// removidos.
+func Break(ctx context.Context, client *datastore.Client, namespace string, force bool) (Lock, error) {
+	key := lockKey(namespace)
+	var removed Lock
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var current Lock
+		if err := tx.Get(key, &current); err != nil {
+			if errors.Is(err, datastore.ErrNoSuchEntity) {
+				return fmt.Errorf("o namespace %s não está travado", namespace)
+			}
+			return err
+		}
+		if !force && !current.Expired() {
+			return &LockedError{Lock: current}
+		}
+		removed = current
+		return tx.Delete(key)
+	})
+	if err != nil {
+		return Lock{}, fmt.Errorf("falha ao remover o lock do namespace %s: %v", namespace, err)
+	}
+	return removed, nil
+}
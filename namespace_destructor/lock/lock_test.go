@@ -0,0 +1,67 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startHeartbeat inicia o heartbeat de um Lease sem Datastore, renovando com `renew`.
+func startHeartbeat(t *testing.T, renew func(ctx context.Context) error) (*Lease, context.Context) {
+	t.Helper()
+	ttl, interval := TTL, HeartbeatInterval
+	TTL, HeartbeatInterval = 100*time.Millisecond, 20*time.Millisecond
+	t.Cleanup(func() { TTL, HeartbeatInterval = ttl, interval })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lease := &Lease{namespace: "tenant", cancel: cancel, stop: make(chan struct{}), done: make(chan struct{})}
+	go lease.heartbeat(ctx, time.Now(), renew)
+	t.Cleanup(func() {
+		cancel()
+		<-lease.done
+	})
+	return lease, ctx
+}
+
+func TestHeartbeatCancelsBeforeExpiry(t *testing.T) {
+	_, ctx := startHeartbeat(t, func(ctx context.Context) error {
+		return errors.New("datastore indisponível")
+	})
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("o contexto não foi cancelado com as renovações falhando")
+	}
+}
+
+func TestHeartbeatCancelsWhenLockIsTaken(t *testing.T) {
+	_, ctx := startHeartbeat(t, func(ctx context.Context) error {
+		return &LockedError{Lock: Lock{Namespace: "tenant", Owner: "outro"}}
+	})
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("o contexto não foi cancelado com o lock de outro processo")
+	}
+}
+
+func TestHeartbeatToleratesTransientFailures(t *testing.T) {
+	var calls atomic.Int32
+	_, ctx := startHeartbeat(t, func(ctx context.Context) error {
+		// Falha uma renovação a cada duas, sem ficar um TTL inteiro sem renovar
+		if calls.Add(1)%2 == 0 {
+			return errors.New("timeout")
+		}
+		return nil
+	})
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("o contexto foi cancelado com o lock sendo renovado")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
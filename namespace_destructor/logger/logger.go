@@ -0,0 +1,190 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formatos de saída dos logs.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+const (
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorRed    = "\033[31m"
+	colorGray   = "\033[90m"
+	colorReset  = "\033[0m"
+)
+
+// RunID identifica a execução atual e é adicionado a todas as linhas de log, permitindo separar
+// os logs de execuções diferentes gravados no mesmo arquivo.
+var RunID = newRunID()
+
+// Setup configura o logger padrão do slog (e do pacote log) com o formato e o nível informados.
+// No formato texto, as cores só são usadas quando a saída é um terminal.
+func Setup(w io.Writer, format, level string) error {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("nível de log %q inválido, use debug, info, warn ou error", level)
+	}
+
+	var handler slog.Handler
+	switch format {
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: logLevel})
+	case FormatText, "":
+		handler = &consoleHandler{w: w, level: logLevel, color: IsTerminal(w), mu: &sync.Mutex{}}
+	default:
+		return fmt.Errorf("formato de log %q inválido, use %s ou %s", format, FormatText, FormatJSON)
+	}
+
+	// O SetDefault também redireciona as mensagens do pacote log (ex: bibliotecas) para o handler
+	slog.SetDefault(slog.New(handler).With("run", RunID))
+	return nil
+}
+
+// Fatal registra o erro e encerra o processo, substituindo o log.Fatalf.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// IsTerminal indica se o writer é um terminal interativo. Writers que envolvem o terminal (ex: o painel
+// de progresso) podem informar isso implementando `Terminal() bool`.
+func IsTerminal(w io.Writer) bool {
+	if terminal, ok := w.(interface{ Terminal() bool }); ok {
+		return terminal.Terminal()
+	}
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func newRunID() string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return time.Now().Format("150405")
+	}
+	return hex.EncodeToString(buf)
+}
+
+// consoleHandler escreve os logs em uma linha legível: `15:04:05 INFO mensagem chave=valor ...`.
+type consoleHandler struct {
+	w      io.Writer
+	level  slog.Level
+	color  bool
+	attrs  []slog.Attr
+	groups []string
+	mu     *sync.Mutex
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(_ context.Context, record slog.Record) error {
+	var line strings.Builder
+	line.WriteString(record.Time.Format("15:04:05"))
+	line.WriteByte(' ')
+	line.WriteString(h.paint(levelColor(record.Level), fmt.Sprintf("%-5s", record.Level.String())))
+	line.WriteByte(' ')
+	line.WriteString(record.Message)
+
+	for _, attr := range h.attrs {
+		h.writeAttr(&line, "", attr)
+	}
+	prefix := strings.Join(h.groups, ".")
+	record.Attrs(func(attr slog.Attr) bool {
+		h.writeAttr(&line, prefix, attr)
+		return true
+	})
+	line.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, line.String())
+	return err
+}
+
+func (h *consoleHandler) writeAttr(line *strings.Builder, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	key := attr.Key
+	if prefix != "" {
+		key = prefix + "." + key
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		for _, nested := range attr.Value.Group() {
+			h.writeAttr(line, key, nested)
+		}
+		return
+	}
+
+	value := attr.Value.String()
+	if strings.ContainsAny(value, " \"=\n") || value == "" {
+		value = fmt.Sprintf("%q", value)
+	}
+	line.WriteByte(' ')
+	line.WriteString(h.paint(colorGray, key+"="))
+	line.WriteString(value)
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr(nil), h.attrs...)
+	prefix := strings.Join(h.groups, ".")
+	for _, attr := range attrs {
+		if prefix != "" {
+			attr.Key = prefix + "." + attr.Key
+		}
+		clone.attrs = append(clone.attrs, attr)
+	}
+	return &clone
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.groups = append(append([]string(nil), h.groups...), name)
+	return &clone
+}
+
+func (h *consoleHandler) paint(color, text string) string {
+	if !h.color {
+		return text
+	}
+	return color + text + colorReset
+}
+
+func levelColor(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return colorRed
+	case level >= slog.LevelWarn:
+		return colorYellow
+	case level >= slog.LevelInfo:
+		return colorGreen
+	}
+	return colorGray
+}
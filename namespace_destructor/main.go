@@ -1,13 +1,23 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"namespace_destructor/audit"
 	"namespace_destructor/clone_data"
+	"namespace_destructor/config"
 	"namespace_destructor/delete_data"
 	"namespace_destructor/get_data"
+	"namespace_destructor/lock"
+	"namespace_destructor/logger"
+	"namespace_destructor/namespace_list"
+	"namespace_destructor/quarantine"
+	"namespace_destructor/report"
+	"namespace_destructor/scheduler"
+	"namespace_destructor/worklist"
 	"os"
 	"strings"
 	"time"
@@ -15,37 +25,40 @@ import (
 	"cloud.google.com/go/datastore"
 )
 
-const (
-	projectDevId  = "dev-clinicorp"
-	projectProdId = "clinicorp-solution"
-	maxTables     = 4
-	colorGreen    = "\033[32m"
-	colorRed      = "\033[31m"
-	colorReset    = "\033[0m"
-)
-
 func main() {
-	LoadConfig()
-	// startProcessToDeleteNamespaces()
+	// As flags globais vêm antes do subcomando, ex: `namespace_destructor -profile prod analyze-backups`
+	global := flag.NewFlagSet("namespace_destructor", flag.ExitOnError)
+	global.Usage = printUsage
+	flags := config.NewFlags(global)
+	global.Parse(os.Args[1:])
+	LoadConfig(flags)
+	defer tracker.Stop()
+
+	// Executa o subcomando informado, ex: `namespace_destructor analyze-backups`
+	if global.NArg() > 0 {
+		runCommand(global.Arg(0), global.Args()[1:])
+		return
+	}
+
 	generateData()
 	// startProcessToCloneData()
 	// subscriber, err := api.GetSubscriberStatus("ortocoi")
 	// if err != nil {
-	// 	log.Fatalf("Erro ao obter o status do assinante: %v", err)
+	// 	logger.Fatal("Erro ao obter o status do assinante", "error", err)
 	// }
-	// fmt.Printf("Status do assinante: %+v\n", subscriber)
+	// slog.Info("Status do assinante", "subscriber", subscriber)
 }
 
 func startProcessToCloneData() {
-	clone_data.CloneData(context.Background(), projectProdId, projectDevId, "CLINICORP_DEFAULTS")
+	clone_data.CloneData(context.Background(), cfg.CloneSourceProjectID, cfg.ProjectID, "CLINICORP_DEFAULTS", tracker)
 }
 
 func generateData() {
 	// Cria o client do Datastore uma vez e o reutiliza
 	ctx := context.Background()
-	client, err := datastore.NewClient(ctx, projectDevId)
+	client, err := datastore.NewClient(ctx, cfg.ProjectID)
 	if err != nil {
-		log.Fatalf("Falha ao criar o cliente do Datastore: %v", err)
+		logger.Fatal("Falha ao criar o cliente do Datastore", "project", cfg.ProjectID, "error", err)
 	}
 	defer client.Close()
 
@@ -56,92 +69,67 @@ func generateData() {
 	// get_data.CheckImagesFromThumb(ctx, client, "laura.br.sp.sertaozinho")
 }
 
-func startProcessToDeleteNamespaces() {
-	// Carrega os namespaces do arquivo namespaces.txt
-	namespaces, err := loadNamespacesFromFile("namespaces.txt")
-	if err != nil {
-		log.Fatalf("Falha ao carregar namespaces do arquivo: %v", err)
-	}
-
-	// Carrega os namespaces seguros do arquivo safeNamespaces.txt
-	safeNamespaces, err := loadNamespacesFromFile("safeNamespaces.txt")
+// deletePendingNamespaces deleta uma vez os namespaces da origem configurada que ainda não foram
+// concluídos, registrando o andamento de cada um ao lado da lista de origem. Retorna a quantidade de
+// namespaces pendentes no início da passada.
+func deletePendingNamespaces(ctx context.Context, client *datastore.Client) (int, error) {
+	// Carrega os namespaces da origem configurada, sem os inválidos e os da lista de seguros
+	namespaces, rejected, err := loadWorklist(ctx, client, namespacesSource)
 	if err != nil {
-		log.Fatalf("Falha ao carregar namespaces seguros do arquivo: %v", err)
+		return 0, fmt.Errorf("falha ao carregar a lista de namespaces %s: %v", namespacesSource.String(), err)
 	}
 
-	// Se não houver namespaces, exibe uma mensagem e termina o processo
-	if len(namespaces) == 0 {
-		fmt.Printf("%sNenhum namespace encontrado para destruição.%s\n", colorRed, colorReset)
-		time.Sleep(10 * time.Second) // Pausa antes de reiniciar
-		return
-	}
-
-	// Cria o client do Datastore uma vez e o reutiliza
-	ctx := context.Background()
-	client, err := datastore.NewClient(ctx, projectDevId)
+	// Mantém apenas os namespaces que ainda não foram concluídos
+	entries, err := namespace_list.ReadStatus(namespacesSource.StatusName())
 	if err != nil {
-		log.Fatalf("Falha ao criar o cliente do Datastore: %v", err)
+		return 0, fmt.Errorf("falha ao carregar o andamento da lista de namespaces %s: %v", namespacesSource.String(), err)
 	}
-	defer client.Close()
-
-	// Executa a deleção para cada namespace de forma sequencial
+	var pending []string
 	for _, namespace := range namespaces {
-		// Ignora namespaces que estão na lista de namespaces seguros
-		if isNamespaceSafe(namespace, safeNamespaces) {
-			fmt.Printf("%sNamespace %s está na lista de safeNamespaces e será ignorado.%s\n", colorGreen, namespace, colorReset)
-			if err := removeNamespaceFromFile("namespaces.txt", namespace); err != nil {
-				fmt.Printf("Erro ao remover o namespace %s do arquivo: %v\n", namespace, err)
-			}
+		if !entries[namespace].Done() {
+			pending = append(pending, namespace)
+		}
+	}
+	namespaces = pending
+	for _, item := range rejected {
+		if entries[item.Namespace].Done() {
 			continue
 		}
+		if item.Reason == worklist.RejectSafe {
+			slog.Info("Namespace está na lista de safeNamespaces e será ignorado", "namespace", item.Namespace)
+		}
+		markNamespace(item.Namespace, namespace_list.StatusSkipped, strings.TrimSpace(item.Reason+" "+item.Detail))
+	}
 
+	if len(namespaces) == 0 {
+		slog.Warn("Nenhum namespace pendente para destruição", "source", namespacesSource.String())
+		return 0, nil
+	}
+
+	// Executa a deleção de até `concurrentNamespaces` namespaces ao mesmo tempo
+	scheduler.Run(ctx, namespaces, cfg.ConcurrentNamespaces, tracker, func(ctx context.Context, namespace string) {
 		// Verifica se o namespace possui tabelas antes de iniciar o processo
 		allKinds, err := get_data.ListKinds(ctx, client, namespace)
 		if err != nil {
-			fmt.Printf("Erro ao listar kinds para o namespace %s: %v\n", namespace, err)
-			continue
+			slog.Error("Erro ao listar kinds", "namespace", namespace, "error", err)
+			markNamespace(namespace, namespace_list.StatusFailed, err.Error())
+			return
 		}
 
 		if len(allKinds) == 0 {
-			fmt.Printf("%sNenhuma tabela encontrada no namespace %s. Removendo do arquivo.%s\n", colorGreen, namespace, colorReset)
-			if err := removeNamespaceFromFile("namespaces.txt", namespace); err != nil {
-				fmt.Printf("Erro ao remover o namespace %s do arquivo: %v\n", namespace, err)
-			}
-			continue
+			slog.Info("Nenhuma tabela encontrada no namespace, marcando como concluído", "namespace", namespace)
+			markNamespace(namespace, namespace_list.StatusProcessed, "sem tabelas")
+			return
 		}
 
-		fmt.Printf("Iniciando processo para o namespace: %s\n", namespace)
-		startDeleteData(ctx, client, namespace, allKinds)
-	}
-
-	// Ao finalizar o processamento, reinicia o main
-	fmt.Println("Processamento completo para todos os namespaces. Reiniciando o processo...")
-	time.Sleep(10 * time.Second)     // Pausa antes de reiniciar
-	startProcessToDeleteNamespaces() // Reinicia o processo
-}
-
-// Função para carregar namespaces a partir de um arquivo
-func loadNamespacesFromFile(filename string) ([]string, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, fmt.Errorf("falha ao abrir o arquivo %s: %v", filename, err)
-	}
-	defer file.Close()
-
-	var namespaces []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		namespace := scanner.Text()
-		if namespace != "" {
-			namespaces = append(namespaces, namespace)
+		slog.Info("Iniciando processo para o namespace", "namespace", namespace, "project", cfg.ProjectID)
+		if _, err := startDeleteData(ctx, client, namespace, allKinds, ""); err != nil {
+			markNamespace(namespace, namespace_list.StatusFailed, err.Error())
+			return
 		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("erro ao ler o arquivo %s: %v", filename, err)
-	}
-
-	return namespaces, nil
+		markNamespace(namespace, namespace_list.StatusProcessed, "")
+	})
+	return len(namespaces), nil
 }
 
 // Função para verificar se um namespace está na lista de namespaces seguros
@@ -154,32 +142,19 @@ func isNamespaceSafe(namespace string, safeNamespaces []string) bool {
 	return false
 }
 
-// Função para remover um namespace do arquivo namespaces.txt
-func removeNamespaceFromFile(filename, namespace string) error {
-	file, err := os.ReadFile(filename)
-	if err != nil {
-		return fmt.Errorf("falha ao ler o arquivo %s: %v", filename, err)
-	}
-
-	// Remove o namespace da lista
-	lines := strings.Split(string(file), "\n")
-	var newLines []string
-	for _, line := range lines {
-		if strings.TrimSpace(line) != namespace {
-			newLines = append(newLines, line)
-		}
-	}
-
-	// Escreve de volta no arquivo
-	err = os.WriteFile(filename, []byte(strings.Join(newLines, "\n")), 0644)
-	if err != nil {
-		return fmt.Errorf("falha ao escrever no arquivo %s: %v", filename, err)
+// markNamespace registra o andamento do namespace ao lado da lista de origem, sem alterar a lista original.
+func markNamespace(namespace, status, detail string) {
+	if err := namespace_list.MarkStatus(namespacesSource.StatusName(), namespace, status, detail); err != nil {
+		slog.Error("Erro ao registrar o andamento do namespace", "namespace", namespace, "status", status, "error", err)
 	}
-
-	return nil
 }
 
-func startDeleteData(ctx context.Context, client *datastore.Client, namespace string, allKinds []string) {
+// startDeleteData deleta os kinds do namespace, verifica se o namespace ficou vazio e registra a deleção
+// na auditoria e no relatório da execução. `planHash` identifica o plano executado pelo apply e é vazio
+// no fluxo legado. Com `quarantine` configurado, o namespace é copiado para a quarentena antes da deleção.
+// Retorna a quantidade de registros deletados por kind, ou um erro se o namespace não pôde ser travado ou
+// copiado para a quarentena, o lock foi perdido durante a deleção ou o namespace não foi verificado vazio.
+func startDeleteData(ctx context.Context, client *datastore.Client, namespace string, allKinds []string, planHash string) (map[string]int64, error) {
 	var kinds []delete_data.KindInfo
 	for _, kind := range allKinds {
 		kinds = append(kinds, delete_data.KindInfo{Kind: kind})
@@ -193,10 +168,93 @@ func startDeleteData(ctx context.Context, client *datastore.Client, namespace st
 		}
 	}
 
-	fmt.Printf("Iniciando deleção de dados para o namespace %s...\n", namespace)
+	slog.Info("Iniciando deleção de dados", "namespace", namespace, "kinds", len(kindsWithoutUnderscore))
 
-	// Executa a deleção das tabelas com limite de 4 simultâneas
-	delete_data.DeleteData(ctx, client, kindsWithoutUnderscore, namespace, maxTables)
+	// Trava o namespace para que outro processo não o delete ou clone ao mesmo tempo
+	lease, lockCtx, err := lock.Acquire(ctx, client, namespace, lock.OperationDelete)
+	if err != nil {
+		slog.Warn("Namespace ignorado, não foi possível travá-lo", "namespace", namespace, "error", err)
+		return nil, err
+	}
+	defer lease.Release()
+
+	// Com a quarentena, copia o namespace antes de deletá-lo; sem a cópia conferida nada é deletado
+	started := time.Now()
+	quarantined := ""
+	if cfg.Quarantine && !quarantine.IsQuarantine(namespace) {
+		target, copied, err := quarantine.Move(lockCtx, client, namespace, tracker)
+		entry := audit.Entry{Action: audit.ActionQuarantineNamespace, Project: cfg.ProjectID, Namespace: namespace, Target: target.Name, Counts: copied, StartedAt: started, PlanHash: planHash}
+		if err != nil {
+			err = fmt.Errorf("falha ao copiar o namespace %s para a quarentena, nada foi deletado: %v", namespace, err)
+			entry.Error = err.Error()
+		}
+		if err := auditLog.Record(ctx, entry); err != nil {
+			slog.Error("Falha ao registrar a quarentena na auditoria", "namespace", namespace, "error", err)
+		}
+		if err != nil {
+			result := report.Namespace{Namespace: namespace, PlanHash: planHash, Error: err.Error(), StartedAt: started.UTC(), FinishedAt: time.Now().UTC()}
+			if err := runReport.Record(result); err != nil {
+				slog.Error("Falha ao registrar o namespace no relatório da execução", "namespace", namespace, "error", err)
+			}
+			return nil, err
+		}
+		quarantined = target.Name
+	}
+
+	var kindNames []string
+	for _, kind := range kindsWithoutUnderscore {
+		kindNames = append(kindNames, kind.Kind)
+	}
+
+	// Registra a deleção antes de executá-la; sem o registro na auditoria nada é deletado
+	intent := audit.Entry{Action: audit.ActionDeleteNamespace, Project: cfg.ProjectID, Namespace: namespace, Target: quarantined, Kinds: kindNames, StartedAt: started, PlanHash: planHash}
+	if err := auditLog.Intent(ctx, intent); err != nil {
+		err = fmt.Errorf("namespace %s não deletado: %v", namespace, err)
+		result := report.Namespace{Namespace: namespace, PlanHash: planHash, Quarantine: quarantined, Error: err.Error(), StartedAt: started.UTC(), FinishedAt: time.Now().UTC()}
+		if err := runReport.Record(result); err != nil {
+			slog.Error("Falha ao registrar o namespace no relatório da execução", "namespace", namespace, "error", err)
+		}
+		return nil, err
+	}
+
+	// Executa a deleção das tabelas com limite de `maxTables` simultâneas e verifica se o namespace ficou vazio
+	deleted, verification, verifyErr := delete_data.DeleteAndVerify(lockCtx, client, kindsWithoutUnderscore, namespace, cfg.MaxTables, planHash != "", tracker)
+
+	entry := audit.Entry{Action: audit.ActionDeleteNamespace, Project: cfg.ProjectID, Namespace: namespace, Target: quarantined, Kinds: kindNames, Counts: deleted, StartedAt: started, PlanHash: planHash, Verified: verification.Empty}
+	var deleteErr error
+	switch {
+	case lockCtx.Err() != nil && ctx.Err() == nil:
+		deleteErr = fmt.Errorf("deleção do namespace %s interrompida: o lock foi perdido", namespace)
+	case verifyErr != nil:
+		deleteErr = fmt.Errorf("falha ao verificar o namespace %s: %v", namespace, verifyErr)
+	case !verification.Empty:
+		deleteErr = fmt.Errorf("o namespace %s continua com os kinds %v depois de %d verificações", namespace, verification.Kinds, verification.Passes)
+	}
+	if deleteErr != nil {
+		entry.Error = deleteErr.Error()
+	}
+	if err := auditLog.Record(ctx, entry); err != nil {
+		slog.Error("Falha ao registrar a deleção na auditoria", "namespace", namespace, "error", err)
+		deleteErr = errors.Join(deleteErr, fmt.Errorf("falha ao registrar a deleção do namespace %s na auditoria: %v", namespace, err))
+		entry.Error = deleteErr.Error()
+	}
+
+	result := report.Namespace{
+		Namespace:     namespace,
+		PlanHash:      planHash,
+		Quarantine:    quarantined,
+		Deleted:       deleted,
+		Remaining:     verification.Remaining,
+		VerifyPasses:  verification.Passes,
+		VerifiedEmpty: verification.Empty,
+		Error:         entry.Error,
+		StartedAt:     started.UTC(),
+		FinishedAt:    time.Now().UTC(),
+	}
+	if err := runReport.Record(result); err != nil {
+		slog.Error("Falha ao registrar o namespace no relatório da execução", "namespace", namespace, "error", err)
+	}
 
-	fmt.Printf("Processo de deleção completo para o namespace %s.\n", namespace)
+	slog.Info("Processo de deleção completo", "namespace", namespace)
+	return deleted, deleteErr
 }
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "namespace_destructor"
+
+// Project é o projeto usado como label nas métricas das deleções. É definido ao carregar a configuração.
+var Project = ""
+
+var (
+	// EntitiesDeleted conta as entidades deletadas do Datastore por projeto.
+	EntitiesDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "entities_deleted_total",
+		Help:      "Entidades deletadas do Datastore.",
+	}, []string{"project"})
+
+	// EntitiesCloned conta as entidades copiadas para o projeto de destino.
+	EntitiesCloned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "entities_cloned_total",
+		Help:      "Entidades copiadas para o projeto de destino.",
+	}, []string{"project"})
+
+	// BatchesFailed conta os lotes que falharam, por projeto e operação (delete, clone).
+	BatchesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "batches_failed_total",
+		Help:      "Lotes de deleção ou clonagem que falharam.",
+	}, []string{"project", "operation"})
+
+	// Retries conta as novas tentativas, por operação (ex: datastore_query, cs_api).
+	Retries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Novas tentativas após falhas temporárias.",
+	}, []string{"operation"})
+
+	// PendingNamespaces é a quantidade de namespaces aguardando deleção na fila atual.
+	PendingNamespaces = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_namespaces",
+		Help:      "Namespaces aguardando deleção.",
+	})
+
+	// RateLimit é a taxa atual do limitador de operações, já considerando as reduções por sobrecarga.
+	RateLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_ops_per_second",
+		Help:      "Operações por segundo permitidas pelo limitador (0 sem limite).",
+	})
+
+	// DatastoreLatency mede a duração das chamadas ao Datastore, por operação.
+	DatastoreLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "datastore_call_duration_seconds",
+		Help:      "Duração das chamadas ao Datastore.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"operation"})
+)
+
+// ObserveDatastore registra a duração de uma chamada ao Datastore iniciada em `start`.
+// Uso: `defer metrics.ObserveDatastore("delete_multi", time.Now())`.
+func ObserveDatastore(operation string, start time.Time) {
+	DatastoreLatency.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Serve expõe as métricas em `/metrics` e a verificação de saúde em `/healthz` no endereço informado.
+// O endereço é reservado antes de retornar, então um erro indica que o servidor não foi iniciado.
+func Serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("falha ao escutar em %s: %v", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			slog.Error("Servidor de métricas encerrado", "addr", addr, "error", err)
+		}
+	}()
+
+	slog.Info("Servidor de métricas iniciado", "addr", listener.Addr().String())
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package namespace_list
+
+import "sync"
+
+// Sem flock, o lock vale apenas entre as goroutines deste processo.
+var fileMu sync.RWMutex
+
+// LockFile trava o arquivo apenas dentro do processo. Retorna a função que o libera.
+func LockFile(filename string, exclusive bool) (func(), error) {
+	if exclusive {
+		fileMu.Lock()
+		return fileMu.Unlock, nil
+	}
+	fileMu.RLock()
+	return fileMu.RUnlock, nil
+}
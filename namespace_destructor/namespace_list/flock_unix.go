@@ -0,0 +1,30 @@
+//go:build unix
+
+package namespace_list
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// LockFile obtém um lock consultivo (flock) em `<arquivo>.lock`. O lock fica em um arquivo separado
+// porque o rename da escrita atômica troca o inode do arquivo da lista. Retorna a função que o libera.
+func LockFile(filename string, exclusive bool) (func(), error) {
+	file, err := os.OpenFile(filename+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao abrir o lock de %s: %v", filename, err)
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("falha ao travar o arquivo %s: %v", filename, err)
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,212 @@
+package namespace_list
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Situações de um namespace no arquivo de andamento (`<lista>.status`).
+const (
+	StatusProcessed = "processed" // deletado, ou sem nenhuma tabela
+	StatusSkipped   = "skipped"   // ignorado, ex: está na lista de namespaces seguros
+	StatusFailed    = "failed"    // a deleção falhou e o namespace será tentado novamente
+)
+
+// Entry é a situação de um namespace da lista no arquivo de andamento.
+type Entry struct {
+	Namespace string
+	Status    string
+	UpdatedAt time.Time
+	Detail    string
+}
+
+// Done indica se o namespace já foi concluído e não precisa ser processado novamente.
+func (e Entry) Done() bool {
+	return e.Status == StatusProcessed || e.Status == StatusSkipped
+}
+
+// Read lê uma lista de namespaces, um por linha. Linhas vazias e comentários (`#` até o fim da linha)
+// são ignorados, e namespaces repetidos aparecem uma única vez, na ordem da primeira ocorrência.
+// A leitura não usa o lock: as escritas substituem o arquivo com um rename, então ele nunca é lido pela
+// metade, e listas apenas lidas (ex: a de namespaces seguros) não ganham um `<arquivo>.lock`.
+func Read(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao abrir o arquivo %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	namespaces, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler o arquivo %s: %v", filename, err)
+	}
+	return namespaces, nil
+}
+
+// Parse lê os namespaces de `r` com as mesmas regras de Read.
+func Parse(r io.Reader) ([]string, error) {
+	var namespaces []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		namespace := strings.TrimSpace(line)
+		if namespace == "" || seen[namespace] {
+			continue
+		}
+		seen[namespace] = true
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces, scanner.Err()
+}
+
+// Write grava a lista de namespaces, um por linha, sem repetições.
+func Write(filename string, namespaces []string) error {
+	unlock, err := LockFile(filename, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var builder strings.Builder
+	seen := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		if seen[namespace] {
+			continue
+		}
+		seen[namespace] = true
+		builder.WriteString(namespace + "\n")
+	}
+	return WriteAtomic(filename, []byte(builder.String()))
+}
+
+// statusFile retorna o arquivo de andamento da lista.
+func statusFile(filename string) string {
+	return filename + ".status"
+}
+
+// ReadStatus lê o arquivo de andamento da lista. Um arquivo inexistente equivale a nenhum andamento.
+// Assim como Read, não usa o lock, pois o MarkStatus substitui o arquivo com um rename.
+func ReadStatus(filename string) (map[string]Entry, error) {
+	return readStatus(statusFile(filename))
+}
+
+// readStatus lê as linhas `namespace<TAB>situação<TAB>data<TAB>detalhe`. Para um namespace repetido,
+// vale a última linha.
+func readStatus(filename string) (map[string]Entry, error) {
+	entries := make(map[string]Entry)
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("falha ao abrir o arquivo %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("linha inválida no arquivo %s: %q", filename, line)
+		}
+		entry := Entry{Namespace: fields[0], Status: fields[1]}
+		if len(fields) > 2 {
+			entry.UpdatedAt, _ = time.Parse(time.RFC3339, fields[2])
+		}
+		if len(fields) > 3 {
+			entry.Detail = fields[3]
+		}
+		entries[entry.Namespace] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao ler o arquivo %s: %v", filename, err)
+	}
+	return entries, nil
+}
+
+// MarkStatus registra a situação do namespace no arquivo de andamento da lista, substituindo a anterior.
+// O arquivo é lido e regravado com o lock exclusivo, para que processos e namespaces processados ao
+// mesmo tempo não percam as atualizações uns dos outros.
+func MarkStatus(filename, namespace, status, detail string) error {
+	unlock, err := LockFile(statusFile(filename), true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := readStatus(statusFile(filename))
+	if err != nil {
+		return err
+	}
+	entries[namespace] = Entry{Namespace: namespace, Status: status, UpdatedAt: time.Now().UTC(), Detail: detail}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "# Andamento de %s: namespace, situação (%s, %s ou %s), data e detalhe\n", filepath.Base(filename), StatusProcessed, StatusSkipped, StatusFailed)
+	for _, name := range names {
+		entry := entries[name]
+		detail := strings.NewReplacer("\t", " ", "\n", " ").Replace(entry.Detail)
+		fmt.Fprintf(&builder, "%s\t%s\t%s\t%s\n", entry.Namespace, entry.Status, entry.UpdatedAt.Format(time.RFC3339), detail)
+	}
+	return WriteAtomic(statusFile(filename), []byte(builder.String()))
+}
+
+// WriteAtomic grava o conteúdo em um arquivo temporário no mesmo diretório e o renomeia sobre o destino,
+// para que uma falha no meio da escrita nunca deixe o arquivo truncado.
+func WriteAtomic(filename string, data []byte) error {
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("falha ao criar o arquivo temporário para %s: %v", filename, err)
+	}
+	defer os.Remove(tmp.Name()) // Não faz nada depois do rename
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("falha ao escrever no arquivo %s: %v", filename, err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("falha ao alterar as permissões de %s: %v", filename, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("falha ao sincronizar o arquivo %s: %v", filename, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("falha ao fechar o arquivo %s: %v", filename, err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("falha ao substituir o arquivo %s: %v", filename, err)
+	}
+
+	// Sincroniza o diretório para que o rename sobreviva a uma queda da máquina
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package pitr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"namespace_destructor/clone_data"
+	"namespace_destructor/metrics"
+	"namespace_destructor/ratelimit"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// Datastore lê as versões antigas e grava as entidades ausentes no projeto do cliente. As leituras
+// históricas são feitas em transações somente leitura com ReadTime, pois as queries ignoram as opções de
+// leitura do cliente. O projeto precisa ter o PITR habilitado para ler além da última hora.
+type Datastore struct {
+	Client *datastore.Client
+}
+
+// Kinds lista os kinds do namespace no instante `at`.
+func (d Datastore) Kinds(ctx context.Context, namespace string, at time.Time) ([]string, error) {
+	var kinds []string
+	err := d.readAt(ctx, at, func(tx *datastore.Transaction) error {
+		query := datastore.NewQuery("__kind__").Namespace(namespace).KeysOnly().Transaction(tx)
+		it := d.Client.Run(ctx, query)
+		for {
+			key, err := it.Next(nil)
+			if err == iterator.Done {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			// Ignora kinds que começam e terminam com "__"
+			if strings.HasPrefix(key.Name, "__") && strings.HasSuffix(key.Name, "__") {
+				continue
+			}
+			kinds = append(kinds, key.Name)
+		}
+	})
+	return kinds, err
+}
+
+// Page lê uma página do kind no instante `at` em uma transação própria, encerrada antes de retornar.
+// Assim nenhuma transação fica aberta enquanto a página é processada, e kinds grandes não esbarram no
+// limite de duração das transações do Datastore.
+func (d Datastore) Page(ctx context.Context, namespace, kind string, at time.Time, cursor string, limit int) ([]*datastore.Key, []datastore.PropertyList, string, error) {
+	var keys []*datastore.Key
+	var entities []datastore.PropertyList
+	next := ""
+	err := d.readAt(ctx, at, func(tx *datastore.Transaction) error {
+		query := datastore.NewQuery(kind).Namespace(namespace).Limit(limit).Transaction(tx)
+		if cursor != "" {
+			start, err := datastore.DecodeCursor(cursor)
+			if err != nil {
+				return fmt.Errorf("cursor inválido: %v", err)
+			}
+			query = query.Start(start)
+		}
+		it := d.Client.Run(ctx, query)
+		for {
+			var entity datastore.PropertyList
+			key, err := it.Next(&entity)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("falha ao iterar registros: %v", err)
+			}
+			keys = append(keys, key)
+			entities = append(entities, entity)
+		}
+		// Uma página incompleta é a última
+		if len(keys) < limit {
+			return nil
+		}
+		end, err := it.Cursor()
+		if err != nil {
+			return fmt.Errorf("falha ao obter o cursor da próxima página: %v", err)
+		}
+		next = end.String()
+		return nil
+	})
+	return keys, entities, next, err
+}
+
+// readAt executa `fn` em uma transação somente leitura no instante `at`.
+func (d Datastore) readAt(ctx context.Context, at time.Time, fn func(tx *datastore.Transaction) error) error {
+	tx, err := d.Client.NewTransaction(ctx, datastore.ReadOnly, datastore.WithReadTime(at))
+	if err != nil {
+		return fmt.Errorf("falha ao iniciar a leitura em %s: %v", at.Format(time.RFC3339), err)
+	}
+	defer tx.Rollback()
+	return fn(tx)
+}
+
+// Missing verifica com GetMulti quais chaves não existem mais.
+func (d Datastore) Missing(ctx context.Context, keys []*datastore.Key) ([]bool, error) {
+	if err := ratelimit.Default.Wait(ctx, len(keys)); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	entities := make([]datastore.PropertyList, len(keys))
+	err := d.Client.GetMulti(ctx, keys, entities)
+	metrics.ObserveDatastore("get_multi", start)
+	ratelimit.Default.Observe(err)
+
+	missing := make([]bool, len(keys))
+	if err == nil {
+		return missing, nil
+	}
+	var multiErr datastore.MultiError
+	if !errors.As(err, &multiErr) {
+		return nil, fmt.Errorf("falha ao verificar os registros atuais: %v", err)
+	}
+	for i, keyErr := range multiErr {
+		switch {
+		case keyErr == nil:
+		case errors.Is(keyErr, datastore.ErrNoSuchEntity):
+			missing[i] = true
+		default:
+			return nil, fmt.Errorf("falha ao verificar o registro %v: %v", keys[i], keyErr)
+		}
+	}
+	return missing, nil
+}
+
+// Put grava as entidades pelo mesmo caminho da clonagem, com o limitador de operações.
+func (d Datastore) Put(ctx context.Context, keys []*datastore.Key, entities []datastore.PropertyList) error {
+	return clone_data.PutEntities(ctx, d.Client, keys, entities)
+}
@@ -0,0 +1,187 @@
+package pitr
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// FakeStore simula em memória o histórico de versões do Datastore, permitindo exercitar Restore sem um
+// projeto com PITR. Cada gravação ou deleção cria uma versão com o seu instante; a leitura em um instante
+// vê a última versão de cada chave até ele, e as leituras fora de Window falham como no Datastore.
+type FakeStore struct {
+	// Now é o instante atual, usado pelas gravações de Put e pela validação das leituras. Padrão: time.Now.
+	Now func() time.Time
+	// Pages é a quantidade de páginas lidas por Page.
+	Pages int
+
+	mu       sync.Mutex
+	keys     map[string]*datastore.Key
+	versions map[string][]fakeVersion
+}
+
+type fakeVersion struct {
+	at      time.Time
+	entity  datastore.PropertyList
+	deleted bool
+}
+
+// NewFakeStore cria um FakeStore vazio.
+func NewFakeStore() *FakeStore {
+	return &FakeStore{Now: time.Now, keys: make(map[string]*datastore.Key), versions: make(map[string][]fakeVersion)}
+}
+
+// Set grava a entidade na chave no instante `at`.
+func (f *FakeStore) Set(at time.Time, key *datastore.Key, entity datastore.PropertyList) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(key, fakeVersion{at: at, entity: entity})
+}
+
+// Delete deleta a entidade da chave no instante `at`.
+func (f *FakeStore) Delete(at time.Time, key *datastore.Key) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(key, fakeVersion{at: at, deleted: true})
+}
+
+// Get retorna a entidade da chave no instante `at` e se ela existia.
+func (f *FakeStore) Get(key *datastore.Key, at time.Time) (datastore.PropertyList, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	version, ok := f.versionAt(key.Encode(), at)
+	return version.entity, ok
+}
+
+// Kinds lista os kinds com alguma entidade no namespace no instante `at`.
+func (f *FakeStore) Kinds(ctx context.Context, namespace string, at time.Time) ([]string, error) {
+	if err := f.checkRead(at); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var kinds []string
+	for _, id := range f.sortedKeys() {
+		key := f.keys[id]
+		if key.Namespace != namespace || seen[key.Kind] {
+			continue
+		}
+		if strings.HasPrefix(key.Kind, "__") && strings.HasSuffix(key.Kind, "__") {
+			continue
+		}
+		if _, ok := f.versionAt(id, at); ok {
+			seen[key.Kind] = true
+			kinds = append(kinds, key.Kind)
+		}
+	}
+	sort.Strings(kinds)
+	return kinds, nil
+}
+
+// Page lê uma página do kind no instante `at`, na ordem das chaves codificadas. O cursor é a última chave
+// codificada da página anterior, então cada página é uma leitura nova, como no Datastore.
+func (f *FakeStore) Page(ctx context.Context, namespace, kind string, at time.Time, cursor string, limit int) ([]*datastore.Key, []datastore.PropertyList, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, "", err
+	}
+	if err := f.checkRead(at); err != nil {
+		return nil, nil, "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Pages++
+
+	var keys []*datastore.Key
+	var entities []datastore.PropertyList
+	for _, id := range f.sortedKeys() {
+		key := f.keys[id]
+		if key.Namespace != namespace || key.Kind != kind || id <= cursor {
+			continue
+		}
+		if len(keys) == limit {
+			return keys, entities, keys[len(keys)-1].Encode(), nil
+		}
+		if version, ok := f.versionAt(id, at); ok {
+			keys = append(keys, key)
+			entities = append(entities, version.entity)
+		}
+	}
+	return keys, entities, "", nil
+}
+
+// Missing indica quais chaves não existem no instante atual.
+func (f *FakeStore) Missing(ctx context.Context, keys []*datastore.Key) ([]bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.Now()
+	missing := make([]bool, len(keys))
+	for i, key := range keys {
+		_, ok := f.versionAt(key.Encode(), now)
+		missing[i] = !ok
+	}
+	return missing, nil
+}
+
+// Put grava as entidades no instante atual.
+func (f *FakeStore) Put(ctx context.Context, keys []*datastore.Key, entities []datastore.PropertyList) error {
+	if len(keys) != len(entities) {
+		return fmt.Errorf("quantidade de chaves (%d) diferente da quantidade de entidades (%d)", len(keys), len(entities))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.Now()
+	for i, key := range keys {
+		f.record(key, fakeVersion{at: now, entity: entities[i]})
+	}
+	return nil
+}
+
+// checkRead rejeita leituras no futuro ou fora de Window, como o Datastore.
+func (f *FakeStore) checkRead(at time.Time) error {
+	now := f.Now()
+	if at.After(now) {
+		return fmt.Errorf("leitura em %s posterior ao instante atual", at.Format(time.RFC3339))
+	}
+	if now.Sub(at) > Window {
+		return fmt.Errorf("leitura em %s fora da janela de %s do PITR", at.Format(time.RFC3339), Window)
+	}
+	return nil
+}
+
+// record adiciona a versão mantendo o histórico da chave ordenado pelo instante.
+func (f *FakeStore) record(key *datastore.Key, version fakeVersion) {
+	id := key.Encode()
+	f.keys[id] = key
+	history := append(f.versions[id], version)
+	sort.SliceStable(history, func(i, j int) bool { return history[i].at.Before(history[j].at) })
+	f.versions[id] = history
+}
+
+// versionAt retorna a última versão da chave até o instante `at`, se a entidade existia.
+func (f *FakeStore) versionAt(id string, at time.Time) (fakeVersion, bool) {
+	var current fakeVersion
+	found := false
+	for _, version := range f.versions[id] {
+		if version.at.After(at) {
+			break
+		}
+		current, found = version, !version.deleted
+	}
+	return current, found
+}
+
+func (f *FakeStore) sortedKeys() []string {
+	ids := make([]string, 0, len(f.keys))
+	for id := range f.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
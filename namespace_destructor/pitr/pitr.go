@@ -0,0 +1,175 @@
+package pitr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"namespace_destructor/clone_data"
+	"namespace_destructor/progress"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Window é o período em que o Datastore mantém as versões antigas das entidades com o PITR habilitado.
+const Window = 7 * 24 * time.Hour
+
+// PageSize é a quantidade de entidades lidas por página de um kind. Cada página é uma leitura própria,
+// para que nenhuma transação fique aberta enquanto as entidades são verificadas e gravadas.
+var PageSize = clone_data.BatchSize
+
+// HistoricalReader lê os dados de um namespace como estavam em um instante passado.
+type HistoricalReader interface {
+	// Kinds lista os kinds do namespace no instante `at`, ignorando os kinds reservados.
+	Kinds(ctx context.Context, namespace string, at time.Time) ([]string, error)
+	// Page lê até `limit` entidades do kind no instante `at`, a partir de `cursor` (vazio na primeira
+	// página). Retorna o cursor da próxima página, vazio quando não há mais entidades.
+	Page(ctx context.Context, namespace, kind string, at time.Time, cursor string, limit int) ([]*datastore.Key, []datastore.PropertyList, string, error)
+}
+
+// Target é o estado atual do Datastore, onde as entidades ausentes são gravadas de volta.
+type Target interface {
+	// Missing indica, para cada chave, se a entidade não existe mais.
+	Missing(ctx context.Context, keys []*datastore.Key) ([]bool, error)
+	// Put grava as entidades com as chaves informadas.
+	Put(ctx context.Context, keys []*datastore.Key, entities []datastore.PropertyList) error
+}
+
+// Options define o que é restaurado por Restore.
+type Options struct {
+	At     time.Time // instante da leitura, validado por CheckReadTime
+	Kinds  []string  // kinds restaurados; vazio restaura todos os kinds existentes no instante
+	DryRun bool      // apenas conta as entidades ausentes, sem gravar
+}
+
+// Result contém as quantidades por kind de uma restauração.
+type Result struct {
+	Read     map[string]int64 // entidades existentes no instante da leitura
+	Missing  map[string]int64 // entidades que não existem mais
+	Restored map[string]int64 // entidades gravadas de volta (zero no dry run)
+}
+
+// CheckReadTime valida o instante de uma leitura PITR em relação a `now`. O instante precisa estar no
+// passado e dentro de Window. Versões com mais de uma hora só podem ser lidas em minutos inteiros, então
+// nesse caso o instante é arredondado para o minuto anterior.
+func CheckReadTime(at, now time.Time) (time.Time, error) {
+	if !at.Before(now) {
+		return at, fmt.Errorf("o instante %s precisa estar no passado", at.Format(time.RFC3339))
+	}
+	if now.Sub(at) > Window {
+		return at, fmt.Errorf("o instante %s está fora da janela de %s do PITR", at.Format(time.RFC3339), Window)
+	}
+	if now.Sub(at) > time.Hour {
+		at = at.Truncate(time.Minute)
+	}
+	return at, nil
+}
+
+// Restore lê os kinds do namespace como estavam em `opts.At` e grava de volta as entidades que não existem
+// mais, em páginas de PageSize entidades. Entidades que ainda existem não são alteradas, mesmo que tenham
+// mudado depois do instante. A falha de um kind não interrompe os demais; os erros são retornados juntos.
+// O andamento de cada kind é informado ao `tracker`, que pode ser nil.
+func Restore(ctx context.Context, reader HistoricalReader, target Target, namespace string, opts Options, tracker *progress.Tracker) (Result, error) {
+	result := Result{Read: map[string]int64{}, Missing: map[string]int64{}, Restored: map[string]int64{}}
+
+	kinds, err := reader.Kinds(ctx, namespace, opts.At)
+	if err != nil {
+		return result, fmt.Errorf("falha ao listar os kinds do namespace %s em %s: %v", namespace, opts.At.Format(time.RFC3339), err)
+	}
+	kinds = filterKinds(kinds, opts.Kinds, namespace)
+
+	var errs []error
+	for _, kind := range kinds {
+		if ctx.Err() != nil {
+			return result, fmt.Errorf("restauração do namespace %s interrompida: %v", namespace, ctx.Err())
+		}
+		slog.Info("Restaurando registros", "namespace", namespace, "kind", kind, "at", opts.At, "dryRun", opts.DryRun)
+		task := tracker.StartTask(namespace, kind, "restaurando", 0)
+		err := restoreKind(ctx, reader, target, namespace, kind, opts, &result, task)
+		task.Finish()
+		if err != nil {
+			slog.Error("Falha ao restaurar registros", "namespace", namespace, "kind", kind, "restored", result.Restored[kind], "error", err)
+			errs = append(errs, fmt.Errorf("kind %s: %v", kind, err))
+			continue
+		}
+		slog.Info("Restauração do kind concluída", "namespace", namespace, "kind", kind,
+			"read", result.Read[kind], "missing", result.Missing[kind], "restored", result.Restored[kind])
+	}
+
+	if len(errs) > 0 {
+		return result, fmt.Errorf("falha ao restaurar o namespace %s: %w", namespace, errors.Join(errs...))
+	}
+	return result, nil
+}
+
+// filterKinds mantém apenas os kinds pedidos, na ordem em que foram lidos. Kinds pedidos que não existiam
+// no instante são apenas informados no log.
+func filterKinds(kinds, wanted []string, namespace string) []string {
+	if len(wanted) == 0 {
+		return kinds
+	}
+	existing := make(map[string]bool, len(kinds))
+	for _, kind := range kinds {
+		existing[kind] = true
+	}
+	selected := make(map[string]bool, len(wanted))
+	for _, kind := range wanted {
+		if !existing[kind] {
+			slog.Warn("Kind não existia no instante da leitura", "namespace", namespace, "kind", kind)
+		}
+		selected[kind] = true
+	}
+	var filtered []string
+	for _, kind := range kinds {
+		if selected[kind] {
+			filtered = append(filtered, kind)
+		}
+	}
+	return filtered
+}
+
+// restoreKind lê o kind página por página; as entidades de cada página que não existem mais são gravadas
+// antes da leitura da próxima.
+func restoreKind(ctx context.Context, reader HistoricalReader, target Target, namespace, kind string, opts Options, result *Result, task *progress.Task) error {
+	cursor := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		keys, entities, next, err := reader.Page(ctx, namespace, kind, opts.At, cursor, PageSize)
+		if err != nil {
+			return err
+		}
+		result.Read[kind] += int64(len(keys))
+
+		// Verifica quais chaves da página não existem mais e grava apenas essas
+		if len(keys) > 0 {
+			missing, err := target.Missing(ctx, keys)
+			if err != nil {
+				return err
+			}
+			var missingKeys []*datastore.Key
+			var missingEntities []datastore.PropertyList
+			for i, absent := range missing {
+				if absent {
+					missingKeys = append(missingKeys, keys[i])
+					missingEntities = append(missingEntities, entities[i])
+				}
+			}
+			result.Missing[kind] += int64(len(missingKeys))
+			if len(missingKeys) > 0 && !opts.DryRun {
+				if err := target.Put(ctx, missingKeys, missingEntities); err != nil {
+					return err
+				}
+				result.Restored[kind] += int64(len(missingKeys))
+			}
+			task.Add(len(keys))
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,206 @@
+package pitr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+var now = time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+func idKey(kind string, id int64) *datastore.Key {
+	key := datastore.IDKey(kind, id, nil)
+	key.Namespace = "tenant"
+	return key
+}
+
+func entity(value int64) datastore.PropertyList {
+	return datastore.PropertyList{{Name: "Value", Value: value}}
+}
+
+// newHistory cria um FakeStore com os kinds Person (ids 1 a 5) e Picture (id 1) gravados três horas
+// atrás. Uma hora atrás, as pessoas 1 e 2 e a imagem foram deletadas e a pessoa 3 foi alterada.
+func newHistory() *FakeStore {
+	store := NewFakeStore()
+	store.Now = func() time.Time { return now }
+
+	created := now.Add(-3 * time.Hour)
+	for id := int64(1); id <= 5; id++ {
+		store.Set(created, idKey("Person", id), entity(id))
+	}
+	store.Set(created, idKey("Picture", 1), entity(100))
+
+	deleted := now.Add(-time.Hour)
+	store.Delete(deleted, idKey("Person", 1))
+	store.Delete(deleted, idKey("Person", 2))
+	store.Delete(deleted, idKey("Picture", 1))
+	store.Set(deleted, idKey("Person", 3), entity(30))
+	return store
+}
+
+func TestCheckReadTime(t *testing.T) {
+	tests := []struct {
+		name string
+		at   time.Time
+		want time.Time
+		err  bool
+	}{
+		{name: "última hora mantém a precisão", at: now.Add(-10*time.Minute - 15*time.Second), want: now.Add(-10*time.Minute - 15*time.Second)},
+		{name: "mais de uma hora arredonda o minuto", at: now.Add(-2*time.Hour + 40*time.Second), want: now.Add(-2 * time.Hour)},
+		{name: "limite da janela", at: now.Add(-Window), want: now.Add(-Window)},
+		{name: "fora da janela", at: now.Add(-Window - time.Minute), err: true},
+		{name: "instante atual", at: now, err: true},
+		{name: "futuro", at: now.Add(time.Minute), err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := CheckReadTime(test.at, now)
+			if test.err {
+				if err == nil {
+					t.Fatalf("CheckReadTime(%s) não retornou erro", test.at)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckReadTime(%s) retornou erro: %v", test.at, err)
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("CheckReadTime(%s) = %s, esperado %s", test.at, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRestoreWritesOnlyMissing(t *testing.T) {
+	store := newHistory()
+	at := now.Add(-2 * time.Hour)
+
+	result, err := Restore(context.Background(), store, store, "tenant", Options{At: at}, nil)
+	if err != nil {
+		t.Fatalf("Restore retornou erro: %v", err)
+	}
+	if result.Read["Person"] != 5 || result.Missing["Person"] != 2 || result.Restored["Person"] != 2 {
+		t.Errorf("Person: lidas %d, ausentes %d, restauradas %d; esperado 5, 2 e 2",
+			result.Read["Person"], result.Missing["Person"], result.Restored["Person"])
+	}
+	if result.Restored["Picture"] != 1 {
+		t.Errorf("Picture: restauradas %d, esperado 1", result.Restored["Picture"])
+	}
+
+	for id := int64(1); id <= 2; id++ {
+		got, ok := store.Get(idKey("Person", id), now)
+		if !ok || got[0].Value != id {
+			t.Errorf("Person %d não foi restaurada com o valor antigo: %v", id, got)
+		}
+	}
+	// A pessoa alterada depois do instante continua com o valor atual
+	if got, _ := store.Get(idKey("Person", 3), now); got[0].Value != int64(30) {
+		t.Errorf("Person 3 foi sobrescrita: %v", got)
+	}
+}
+
+func TestRestoreDryRun(t *testing.T) {
+	store := newHistory()
+
+	result, err := Restore(context.Background(), store, store, "tenant", Options{At: now.Add(-2 * time.Hour), DryRun: true}, nil)
+	if err != nil {
+		t.Fatalf("Restore retornou erro: %v", err)
+	}
+	if result.Missing["Person"] != 2 || result.Missing["Picture"] != 1 {
+		t.Errorf("ausentes = %v, esperado Person 2 e Picture 1", result.Missing)
+	}
+	if len(result.Restored) != 0 {
+		t.Errorf("o dry run restaurou %v", result.Restored)
+	}
+	if _, ok := store.Get(idKey("Person", 1), now); ok {
+		t.Error("o dry run gravou a Person 1")
+	}
+}
+
+func TestRestoreKindFilter(t *testing.T) {
+	store := newHistory()
+
+	result, err := Restore(context.Background(), store, store, "tenant", Options{At: now.Add(-2 * time.Hour), Kinds: []string{"Picture", "Unknown"}}, nil)
+	if err != nil {
+		t.Fatalf("Restore retornou erro: %v", err)
+	}
+	if _, ok := result.Read["Person"]; ok {
+		t.Errorf("Person foi lido sem estar no filtro: %v", result.Read)
+	}
+	if result.Restored["Picture"] != 1 {
+		t.Errorf("Picture: restauradas %d, esperado 1", result.Restored["Picture"])
+	}
+	if _, ok := store.Get(idKey("Person", 1), now); ok {
+		t.Error("Person 1 foi restaurada sem estar no filtro")
+	}
+}
+
+func TestRestoreBeforeCreation(t *testing.T) {
+	store := newHistory()
+
+	// Antes da criação não existia nada para restaurar
+	result, err := Restore(context.Background(), store, store, "tenant", Options{At: now.Add(-4 * time.Hour)}, nil)
+	if err != nil {
+		t.Fatalf("Restore retornou erro: %v", err)
+	}
+	if len(result.Read) != 0 || len(result.Restored) != 0 {
+		t.Errorf("resultado = %+v, esperado vazio", result)
+	}
+}
+
+func TestFakeStoreRejectsReadsOutsideWindow(t *testing.T) {
+	store := newHistory()
+
+	if _, err := store.Kinds(context.Background(), "tenant", now.Add(-Window-time.Minute)); err == nil {
+		t.Error("leitura fora da janela não retornou erro")
+	}
+	if _, err := Restore(context.Background(), store, store, "tenant", Options{At: now.Add(time.Minute)}, nil); err == nil {
+		t.Error("restauração de um instante futuro não retornou erro")
+	}
+}
+
+// pageHook chama `afterPage` depois de cada página lida do FakeStore.
+type pageHook struct {
+	*FakeStore
+	afterPage func(page int)
+}
+
+func (p pageHook) Page(ctx context.Context, namespace, kind string, at time.Time, cursor string, limit int) ([]*datastore.Key, []datastore.PropertyList, string, error) {
+	keys, entities, next, err := p.FakeStore.Page(ctx, namespace, kind, at, cursor, limit)
+	p.afterPage(p.FakeStore.Pages)
+	return keys, entities, next, err
+}
+
+func TestRestoreReadsInPages(t *testing.T) {
+	pageSize := PageSize
+	PageSize = 2
+	defer func() { PageSize = pageSize }()
+
+	store := newHistory()
+	at := now.Add(-2 * time.Hour)
+
+	// Entre a primeira e a segunda página a pessoa 4 é deletada e a 6 é criada: cada página é uma leitura
+	// nova, mas todas continuam vendo o instante `at`
+	reader := pageHook{FakeStore: store, afterPage: func(page int) {
+		if page == 1 {
+			store.Delete(now, idKey("Person", 4))
+			store.Set(now, idKey("Person", 6), entity(6))
+		}
+	}}
+
+	result, err := Restore(context.Background(), reader, store, "tenant", Options{At: at, Kinds: []string{"Person"}}, nil)
+	if err != nil {
+		t.Fatalf("Restore retornou erro: %v", err)
+	}
+	if store.Pages != 3 {
+		t.Errorf("páginas lidas = %d, esperado 3 para 5 pessoas", store.Pages)
+	}
+	if result.Read["Person"] != 5 || result.Restored["Person"] != 3 {
+		t.Errorf("Person: lidas %d, restauradas %d; esperado 5 e 3", result.Read["Person"], result.Restored["Person"])
+	}
+	if got, ok := store.Get(idKey("Person", 4), now); !ok || got[0].Value != int64(4) {
+		t.Errorf("Person 4, deletada durante a leitura, não foi restaurada: %v", got)
+	}
+}
@@ -0,0 +1,199 @@
+package plan
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"namespace_destructor/config"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Papéis de quem aprova um plano.
+const (
+	RoleAuthor   = "author"
+	RoleReviewer = "reviewer"
+)
+
+var approverName = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// Key é a chave de assinatura de um operador. A chave pública correspondente deve estar em Approvers
+// para que as aprovações sejam aceitas.
+type Key struct {
+	Name       string `json:"name"`
+	PrivateKey string `json:"privateKey"` // ed25519 em base64
+}
+
+// Approval é a aprovação de um plano por um operador, assinada com a sua chave.
+type Approval struct {
+	PlanHash   string    `json:"planHash"`
+	Approver   string    `json:"approver"`
+	Role       string    `json:"role"`
+	ApprovedAt time.Time `json:"approvedAt"`
+	Signature  string    `json:"signature"`
+}
+
+func (a Approval) message() []byte {
+	return []byte(fmt.Sprintf("namespace_destructor/approval\n%s\n%s\n%s\n%s", a.PlanHash, a.Approver, a.Role, a.ApprovedAt.Format(time.RFC3339Nano)))
+}
+
+// GenerateKey cria uma nova chave para o operador e retorna a chave pública em base64.
+func GenerateKey(name string) (*Key, string, error) {
+	if !approverName.MatchString(name) {
+		return nil, "", fmt.Errorf("nome %q inválido, use apenas letras, números, ponto, hífen e underline", name)
+	}
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("falha ao gerar a chave: %v", err)
+	}
+	key := &Key{Name: name, PrivateKey: base64.StdEncoding.EncodeToString(private)}
+	return key, base64.StdEncoding.EncodeToString(public), nil
+}
+
+// WriteKey grava a chave em um arquivo legível apenas pelo usuário.
+func WriteKey(filename string, key *Key) error {
+	data, err := json.MarshalIndent(key, "", "  ")
+	if err != nil {
+		return fmt.Errorf("falha ao codificar a chave: %v", err)
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("falha ao escrever a chave %s: %v", filename, err)
+	}
+	return nil
+}
+
+// LoadKey lê a chave de um arquivo gerado por WriteKey.
+func LoadKey(filename string) (*Key, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler a chave %s: %v", filename, err)
+	}
+	var key Key
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("falha ao decodificar a chave %s: %v", filename, err)
+	}
+	if _, err := key.private(); err != nil {
+		return nil, fmt.Errorf("chave %s inválida: %v", filename, err)
+	}
+	return &key, nil
+}
+
+func (k *Key) private() (ed25519.PrivateKey, error) {
+	data, err := base64.StdEncoding.DecodeString(k.PrivateKey)
+	if err != nil || len(data) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("chave privada ed25519 inválida")
+	}
+	return ed25519.PrivateKey(data), nil
+}
+
+// Approve assina o plano com a chave e grava a aprovação ao lado do arquivo do plano, em
+// `<plano>.approval.<nome>.json`.
+func Approve(planFile string, plan *Plan, key *Key, role string) (string, error) {
+	private, err := key.private()
+	if err != nil {
+		return "", err
+	}
+
+	approval := Approval{PlanHash: plan.Hash, Approver: key.Name, Role: role, ApprovedAt: time.Now().UTC()}
+	approval.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(private, approval.message()))
+
+	data, err := json.MarshalIndent(approval, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("falha ao codificar a aprovação: %v", err)
+	}
+	filename := fmt.Sprintf("%s.approval.%s.json", planFile, key.Name)
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("falha ao escrever a aprovação %s: %v", filename, err)
+	}
+	return filename, nil
+}
+
+// LoadApprovals lê as aprovações gravadas ao lado do arquivo do plano.
+func LoadApprovals(planFile string) ([]Approval, error) {
+	files, err := filepath.Glob(planFile + ".approval.*.json")
+	if err != nil {
+		return nil, fmt.Errorf("falha ao listar as aprovações do plano %s: %v", planFile, err)
+	}
+
+	var approvals []Approval
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler a aprovação %s: %v", file, err)
+		}
+		var approval Approval
+		if err := json.Unmarshal(data, &approval); err != nil {
+			return nil, fmt.Errorf("falha ao decodificar a aprovação %s: %v", file, err)
+		}
+		approvals = append(approvals, approval)
+	}
+	return approvals, nil
+}
+
+// RequiresApproval indica se o plano precisa da aprovação de duas pessoas: todo plano do projeto de
+// produção ou gerado com o perfil de produção.
+func (p *Plan) RequiresApproval() bool {
+	return p.Project == config.ProjectProdId || p.Profile == config.ProfileProd
+}
+
+// CheckApprovals verifica se o plano foi assinado pelo autor e aprovado por pelo menos um revisor
+// diferente do autor. Apenas as assinaturas das chaves públicas em `trusted` (nome -> chave em base64)
+// são aceitas; as demais são ignoradas. Uma mesma chave pública não pode assinar por dois operadores, o
+// que impediria a regra das duas pessoas.
+func (p *Plan) CheckApprovals(approvals []Approval, trusted map[string]string) error {
+	owners := make(map[string]string, len(trusted))
+	for name, public := range trusted {
+		if other, ok := owners[public]; ok {
+			return fmt.Errorf("%s e %s usam a mesma chave pública em Approvers", other, name)
+		}
+		owners[public] = name
+	}
+
+	authorSigned := false
+	var reviewers []string
+	for _, approval := range approvals {
+		if err := approval.verify(p.Hash, trusted); err != nil {
+			slog.Warn("Aprovação ignorada", "approver", approval.Approver, "error", err)
+			continue
+		}
+		switch {
+		case approval.Approver == p.Author && approval.Role == RoleAuthor:
+			authorSigned = true
+		case approval.Approver != p.Author && approval.Role == RoleReviewer:
+			reviewers = append(reviewers, approval.Approver)
+		}
+	}
+
+	if !authorSigned {
+		return fmt.Errorf("o plano não foi assinado pelo autor %s, gere o plano com -key", p.Author)
+	}
+	if len(reviewers) == 0 {
+		return fmt.Errorf("o plano só tem a aprovação do autor %s, é necessária a aprovação de outro operador com o comando approve", p.Author)
+	}
+	slog.Info("Plano aprovado", "author", p.Author, "reviewers", reviewers)
+	return nil
+}
+
+func (a Approval) verify(planHash string, trusted map[string]string) error {
+	if a.PlanHash != planHash {
+		return fmt.Errorf("a aprovação é de outro plano")
+	}
+	encoded, ok := trusted[a.Approver]
+	if !ok {
+		return fmt.Errorf("%s não está na lista de Approvers", a.Approver)
+	}
+	public, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(public) != ed25519.PublicKeySize {
+		return fmt.Errorf("chave pública de %s inválida em Approvers", a.Approver)
+	}
+	signature, err := base64.StdEncoding.DecodeString(a.Signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(public), a.message(), signature) {
+		return fmt.Errorf("assinatura inválida")
+	}
+	return nil
+}
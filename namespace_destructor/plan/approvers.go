@@ -0,0 +1,9 @@
+package plan
+
+// Approvers são os operadores que podem assinar e aprovar planos de produção (nome -> chave pública ed25519
+// em base64, gerada pelo comando keygen). A lista fica no código, e não na configuração, para que quem
+// executa a destruição não consiga incluir chaves próprias: novas chaves entram por um pull request
+// revisado e valem a partir do próximo build.
+var Approvers = map[string]string{
+	// "maria": "base64...",
+}
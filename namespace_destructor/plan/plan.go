@@ -0,0 +1,228 @@
+package plan
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"namespace_destructor/get_data"
+	"namespace_destructor/worklist"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Version é a versão do formato do arquivo de plano.
+const Version = 1
+
+// Motivos para um namespace da lista não entrar no plano.
+const (
+	SkipSafe    = worklist.RejectSafe    // está na lista de namespaces seguros
+	SkipInvalid = worklist.RejectInvalid // não é um nome de namespace válido
+	SkipEmpty   = "empty"                // não possui nenhuma tabela
+)
+
+// Plan é o conjunto fixo de namespaces e kinds a serem destruídos, revisado antes da execução.
+// O apply executa exatamente o plano e recusa se o conteúdo do plano ou das listas de entrada mudou.
+type Plan struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Author    string    `json:"author"`
+	Host      string    `json:"host"`
+	Project   string    `json:"project"`
+	Profile   string    `json:"profile"`
+
+	NamespacesSource   string `json:"namespacesSource,omitempty"` // origem da lista, quando não é um arquivo
+	NamespacesFile     string `json:"namespacesFile"`
+	NamespacesHash     string `json:"namespacesHash"`
+	SafeNamespacesFile string `json:"safeNamespacesFile"`
+	SafeNamespacesHash string `json:"safeNamespacesHash"`
+
+	Namespaces []NamespacePlan `json:"namespaces"`
+	Skipped    []Skipped       `json:"skipped,omitempty"`
+	Entities   int64           `json:"entities"` // total estimado de entidades do plano
+
+	Hash string `json:"hash"` // hash do conteúdo do plano, sem este campo
+}
+
+// NamespacePlan são os kinds de um namespace e a quantidade estimada de entidades de cada um,
+// segundo __Stat_Ns_Kind__.
+type NamespacePlan struct {
+	Namespace string     `json:"namespace"`
+	Kinds     []KindPlan `json:"kinds"`
+	Entities  int64      `json:"entities"`
+}
+
+// KindPlan é um kind do namespace com a quantidade estimada de entidades.
+type KindPlan struct {
+	Kind  string `json:"kind"`
+	Count int64  `json:"count"`
+}
+
+// Skipped é um namespace da lista de entrada que ficou fora do plano.
+type Skipped struct {
+	Namespace string `json:"namespace"`
+	Reason    string `json:"reason"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// KindNames retorna os nomes dos kinds do namespace.
+func (n NamespacePlan) KindNames() []string {
+	names := make([]string, len(n.Kinds))
+	for i, kind := range n.Kinds {
+		names[i] = kind.Kind
+	}
+	return names
+}
+
+// Find retorna o plano do namespace, se ele estiver no plano.
+func (p *Plan) Find(namespace string) (NamespacePlan, bool) {
+	for _, item := range p.Namespaces {
+		if item.Namespace == namespace {
+			return item, true
+		}
+	}
+	return NamespacePlan{}, false
+}
+
+// Build monta o plano a partir da lista de trabalho (worklist.Filter), retirando os namespaces vazios.
+// Os namespaces rejeitados pela lista de trabalho são registrados em Skipped. Os hashes das listas são
+// gravados no plano para que o apply detecte alterações; quando a lista não vem de um arquivo
+// (NamespacesFile vazio), o hash é o da própria lista.
+func Build(ctx context.Context, client *datastore.Client, plan Plan, namespaces []string, rejected []worklist.Rejected) (*Plan, error) {
+	var err error
+	if plan.NamespacesFile != "" {
+		if plan.NamespacesHash, err = HashFile(plan.NamespacesFile); err != nil {
+			return nil, err
+		}
+	} else {
+		sum := sha256.Sum256([]byte(strings.Join(namespaces, "\n")))
+		plan.NamespacesHash = hex.EncodeToString(sum[:])
+	}
+	if plan.SafeNamespacesHash, err = HashFile(plan.SafeNamespacesFile); err != nil {
+		return nil, err
+	}
+	plan.Version = Version
+	plan.CreatedAt = time.Now().UTC()
+
+	for _, item := range rejected {
+		plan.Skipped = append(plan.Skipped, Skipped{Namespace: item.Namespace, Reason: item.Reason, Detail: item.Detail})
+	}
+
+	for _, namespace := range namespaces {
+		kinds, err := get_data.ListKinds(ctx, client, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if len(kinds) == 0 {
+			plan.Skipped = append(plan.Skipped, Skipped{Namespace: namespace, Reason: SkipEmpty})
+			continue
+		}
+
+		counts, err := get_data.KindEntityCounts(ctx, client, namespace)
+		if err != nil {
+			slog.Warn("Não foi possível obter a quantidade de registros dos kinds", "namespace", namespace, "error", err)
+		}
+		sort.Strings(kinds)
+		item := NamespacePlan{Namespace: namespace}
+		for _, kind := range kinds {
+			item.Kinds = append(item.Kinds, KindPlan{Kind: kind, Count: counts[kind]})
+			item.Entities += counts[kind]
+		}
+		plan.Namespaces = append(plan.Namespaces, item)
+		plan.Entities += item.Entities
+	}
+
+	slog.Info("Plano montado", "namespaces", len(plan.Namespaces), "skipped", len(plan.Skipped), "entities", plan.Entities)
+	return &plan, nil
+}
+
+// computeHash calcula o hash do plano sem o campo Hash.
+func (p Plan) computeHash() (string, error) {
+	p.Hash = ""
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("falha ao codificar o plano: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Write calcula o hash do plano e o grava no arquivo.
+func Write(filename string, plan *Plan) error {
+	hash, err := plan.computeHash()
+	if err != nil {
+		return err
+	}
+	plan.Hash = hash
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("falha ao codificar o plano: %v", err)
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("falha ao escrever o plano %s: %v", filename, err)
+	}
+	return nil
+}
+
+// Load lê o plano e verifica se o conteúdo não foi alterado depois de gravado.
+func Load(filename string) (*Plan, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler o plano %s: %v", filename, err)
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("falha ao decodificar o plano %s: %v", filename, err)
+	}
+	if plan.Version != Version {
+		return nil, fmt.Errorf("versão %d do plano %s não suportada", plan.Version, filename)
+	}
+
+	hash, err := plan.computeHash()
+	if err != nil {
+		return nil, err
+	}
+	if hash != plan.Hash {
+		return nil, fmt.Errorf("o plano %s foi alterado depois de gerado: hash não confere", filename)
+	}
+	return &plan, nil
+}
+
+// CheckInputs verifica se as listas de namespaces usadas para montar o plano continuam iguais. Uma lista
+// que não veio de um arquivo não é verificada: os seus namespaces estão fixados no próprio plano.
+func (p *Plan) CheckInputs() error {
+	inputs := []struct{ file, hash string }{
+		{p.NamespacesFile, p.NamespacesHash},
+		{p.SafeNamespacesFile, p.SafeNamespacesHash},
+	}
+	for _, input := range inputs {
+		if input.file == "" {
+			continue
+		}
+		hash, err := HashFile(input.file)
+		if err != nil {
+			return err
+		}
+		if hash != input.hash {
+			return fmt.Errorf("o arquivo %s mudou desde a geração do plano, gere um novo plano", input.file)
+		}
+	}
+	return nil
+}
+
+// HashFile retorna o hash SHA-256 do conteúdo do arquivo.
+func HashFile(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("falha ao ler o arquivo %s: %v", filename, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,243 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ttyInterval = 500 * time.Millisecond
+	logInterval = 30 * time.Second
+)
+
+// Tracker acompanha o andamento das tarefas em execução (ex: a deleção de cada kind). Em um terminal,
+// mostra um painel atualizado continuamente no fim da saída; fora de um terminal, registra o andamento
+// em linhas de log periódicas. Todos os métodos aceitam um Tracker nil, que não mostra nada.
+type Tracker struct {
+	out      io.Writer
+	tty      bool
+	interval time.Duration
+
+	mu       sync.Mutex
+	tasks    []*Task
+	finished int // namespaces concluídos da fila atual
+	total    int
+	lines    int // linhas do painel desenhadas na última atualização
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New cria o Tracker e inicia a sua atualização periódica até a chamada de Stop.
+func New(out *os.File) *Tracker {
+	info, err := out.Stat()
+	tty := err == nil && info.Mode()&os.ModeCharDevice != 0
+
+	tracker := &Tracker{out: out, tty: tty, interval: logInterval, stop: make(chan struct{}), done: make(chan struct{})}
+	if tty {
+		tracker.interval = ttyInterval
+	}
+	go tracker.loop()
+	return tracker
+}
+
+// Terminal indica se o painel é desenhado em um terminal. Usado pelo logger para decidir se usa cores.
+func (t *Tracker) Terminal() bool {
+	return t != nil && t.tty
+}
+
+// Write escreve na saída sem corromper o painel: o painel é apagado, o texto é escrito e o painel
+// é desenhado novamente na próxima atualização. Deve ser usado como saída dos logs.
+func (t *Tracker) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clear()
+	return t.out.Write(p)
+}
+
+// Stop interrompe as atualizações e apaga o painel.
+func (t *Tracker) Stop() {
+	if t == nil {
+		return
+	}
+	select {
+	case <-t.stop:
+		return
+	default:
+		close(t.stop)
+	}
+	<-t.done
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clear()
+}
+
+// SetQueue informa quantos namespaces da fila atual já foram concluídos. Com `total` zero, o
+// andamento da fila deixa de ser mostrado.
+func (t *Tracker) SetQueue(finished, total int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.finished, t.total = finished, total
+}
+
+// StartTask registra uma nova tarefa. `total` é a quantidade estimada de registros (ex: a contagem de
+// __Stat_Ns_Kind__) e pode ser zero quando desconhecida.
+func (t *Tracker) StartTask(namespace, kind, action string, total int64) *Task {
+	task := &Task{tracker: t, Namespace: namespace, Kind: kind, Action: action, Total: total, started: time.Now()}
+	if t == nil {
+		return task
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tasks = append(t.tasks, task)
+	return task
+}
+
+func (t *Tracker) remove(task *Task) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, current := range t.tasks {
+		if current == task {
+			t.tasks = append(t.tasks[:i], t.tasks[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *Tracker) loop() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			if t.tty {
+				t.draw()
+			} else {
+				t.logProgress()
+			}
+		}
+	}
+}
+
+// clear apaga o painel desenhado. Deve ser chamado com o mutex travado.
+func (t *Tracker) clear() {
+	if t.lines == 0 {
+		return
+	}
+	fmt.Fprintf(t.out, "\033[%dA\033[J", t.lines)
+	t.lines = 0
+}
+
+// draw redesenha o painel no fim da saída.
+func (t *Tracker) draw() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var lines []string
+	if t.total > 0 {
+		lines = append(lines, fmt.Sprintf("Namespaces concluídos: %d/%d", t.finished, t.total))
+	}
+	for _, task := range t.tasks {
+		lines = append(lines, "  "+task.summary())
+	}
+
+	t.clear()
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Fprint(t.out, strings.Join(lines, "\n")+"\n")
+	t.lines = len(lines)
+}
+
+// logProgress registra uma linha de log por tarefa em execução.
+func (t *Tracker) logProgress() {
+	t.mu.Lock()
+	tasks := append([]*Task(nil), t.tasks...)
+	finished, total := t.finished, t.total
+	t.mu.Unlock()
+
+	if total > 0 && len(tasks) > 0 {
+		slog.Info("Progresso da fila", "finished", finished, "total", total)
+	}
+	for _, task := range tasks {
+		done, rate, eta := task.stats()
+		slog.Info("Progresso", "namespace", task.Namespace, "kind", task.Kind, "action", task.Action,
+			"done", done, "total", task.Total, "rate", fmt.Sprintf("%.0f/s", rate), "eta", formatETA(eta))
+	}
+}
+
+// Task é uma tarefa acompanhada pelo Tracker. O contador é atômico e pode ser incrementado por
+// várias goroutines.
+type Task struct {
+	tracker   *Tracker
+	Namespace string
+	Kind      string
+	Action    string
+	Total     int64
+
+	done    atomic.Int64
+	started time.Time
+}
+
+// Add soma `n` registros processados.
+func (t *Task) Add(n int) {
+	t.done.Add(int64(n))
+}
+
+// Done retorna a quantidade de registros processados.
+func (t *Task) Done() int64 {
+	return t.done.Load()
+}
+
+// Finish remove a tarefa do painel.
+func (t *Task) Finish() {
+	t.tracker.remove(t)
+}
+
+// stats retorna os registros processados, a taxa por segundo e o tempo estimado para terminar
+// (negativo quando desconhecido).
+func (t *Task) stats() (int64, float64, time.Duration) {
+	done := t.done.Load()
+	elapsed := time.Since(t.started).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(done) / elapsed
+	}
+	eta := time.Duration(-1)
+	if t.Total > done && rate > 0 {
+		eta = time.Duration(float64(t.Total-done)/rate) * time.Second
+	}
+	return done, rate, eta
+}
+
+func (t *Task) summary() string {
+	done, rate, eta := t.stats()
+	percent := ""
+	if t.Total > 0 {
+		percent = fmt.Sprintf(" %3d%%", min(100, done*100/t.Total))
+	}
+	return fmt.Sprintf("%-50s %s %d/%d%s  %.0f/s  ETA %s", t.Namespace+" "+t.Kind, t.Action, done, t.Total, percent, rate, formatETA(eta))
+}
+
+func formatETA(eta time.Duration) string {
+	if eta < 0 {
+		return "?"
+	}
+	return eta.Round(time.Second).String()
+}
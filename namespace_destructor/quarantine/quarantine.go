@@ -0,0 +1,190 @@
+package quarantine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"namespace_destructor/clone_data"
+	"namespace_destructor/delete_data"
+	"namespace_destructor/get_data"
+	"namespace_destructor/progress"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Prefix é o início do nome dos namespaces de quarentena: `__quarantine__.<original>.<data>T<hora>`.
+const Prefix = "__quarantine__."
+
+// dateLayout é o formato do instante no nome do namespace de quarentena, com segundos para que o mesmo
+// namespace possa ser colocado em quarentena mais de uma vez no mesmo dia.
+const dateLayout = "20060102T150405"
+
+// dayLayout é o formato das quarentenas antigas, que tinham apenas o dia no nome.
+const dayLayout = "20060102"
+
+// maxNamespaceLength é o tamanho máximo de um nome de namespace no Datastore.
+const maxNamespaceLength = 100
+
+// Namespace é uma cópia de um namespace em quarentena.
+type Namespace struct {
+	Name     string    // namespace da quarentena
+	Original string    // namespace de onde os dados vieram
+	Date     time.Time // instante em que o namespace foi colocado em quarentena
+}
+
+// Expired indica se a cópia passou do período de retenção e pode ser removida.
+func (n Namespace) Expired(retention time.Duration) bool {
+	return time.Since(n.Date) >= retention
+}
+
+// IsQuarantine indica se o namespace é uma cópia em quarentena.
+func IsQuarantine(namespace string) bool {
+	return strings.HasPrefix(namespace, Prefix)
+}
+
+// NameFor retorna o namespace de quarentena do namespace original no instante informado.
+func NameFor(original string, date time.Time) (string, error) {
+	name := Prefix + original + "." + date.UTC().Format(dateLayout)
+	if len(name) > maxNamespaceLength {
+		return "", fmt.Errorf("o namespace %s é longo demais para a quarentena (%s tem %d caracteres, máximo %d)", original, name, len(name), maxNamespaceLength)
+	}
+	return name, nil
+}
+
+// Parse interpreta o nome de um namespace de quarentena.
+func Parse(name string) (Namespace, bool) {
+	rest, ok := strings.CutPrefix(name, Prefix)
+	if !ok {
+		return Namespace{}, false
+	}
+	i := strings.LastIndex(rest, ".")
+	if i <= 0 {
+		return Namespace{}, false
+	}
+	date, err := time.Parse(dateLayout, rest[i+1:])
+	if err != nil {
+		if date, err = time.Parse(dayLayout, rest[i+1:]); err != nil {
+			return Namespace{}, false
+		}
+	}
+	return Namespace{Name: name, Original: rest[:i], Date: date}, true
+}
+
+// List retorna os namespaces em quarentena do projeto, do mais antigo para o mais recente.
+func List(ctx context.Context, client *datastore.Client) ([]Namespace, error) {
+	names, err := get_data.ListNamespacesWithPrefix(ctx, client, Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var namespaces []Namespace
+	for _, name := range names {
+		namespace, ok := Parse(name)
+		if !ok {
+			slog.Warn("Namespace com o prefixo de quarentena em formato desconhecido", "namespace", name)
+			continue
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		if !namespaces[i].Date.Equal(namespaces[j].Date) {
+			return namespaces[i].Date.Before(namespaces[j].Date)
+		}
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	return namespaces, nil
+}
+
+// Latest retorna a cópia em quarentena mais recente do namespace original.
+func Latest(ctx context.Context, client *datastore.Client, original string) (Namespace, error) {
+	namespaces, err := List(ctx, client)
+	if err != nil {
+		return Namespace{}, err
+	}
+	for i := len(namespaces) - 1; i >= 0; i-- {
+		if namespaces[i].Original == original {
+			return namespaces[i], nil
+		}
+	}
+	return Namespace{}, fmt.Errorf("nenhuma cópia do namespace %s em quarentena", original)
+}
+
+// NewTarget retorna um namespace de quarentena novo para o namespace original no instante atual.
+// Retorna um erro se o namespace de quarentena já tiver dados, pois a conferência da cópia não
+// distinguiria os registros antigos dos novos.
+func NewTarget(ctx context.Context, client *datastore.Client, original string) (Namespace, error) {
+	name, err := NameFor(original, time.Now())
+	if err != nil {
+		return Namespace{}, err
+	}
+	target, _ := Parse(name)
+
+	existing, err := get_data.ListKinds(ctx, client, name)
+	if err != nil {
+		return target, err
+	}
+	if len(existing) > 0 {
+		return target, fmt.Errorf("o namespace de quarentena %s já existe com os kinds %v", name, existing)
+	}
+	return target, nil
+}
+
+// Move copia o namespace para a quarentena e confere se a cópia tem todos os registros do original.
+// O original não é alterado: a deleção fica a cargo de quem chama, depois que a cópia foi conferida.
+// Se o namespace de quarentena já tiver dados, nada é copiado (ver NewTarget). Retorna a cópia e as
+// entidades copiadas por kind.
+func Move(ctx context.Context, client *datastore.Client, namespace string, tracker *progress.Tracker) (Namespace, map[string]int64, error) {
+	target, err := NewTarget(ctx, client, namespace)
+	if err != nil {
+		return target, nil, err
+	}
+	name := target.Name
+
+	slog.Info("Copiando namespace para a quarentena", "namespace", namespace, "quarantine", name)
+	copied, err := clone_data.CopyNamespace(ctx, client, client, namespace, name, tracker)
+	if err != nil {
+		return target, copied, err
+	}
+	if err := checkCopy(ctx, client, namespace, name); err != nil {
+		return target, copied, err
+	}
+	slog.Info("Namespace copiado para a quarentena", "namespace", namespace, "quarantine", name)
+	return target, copied, nil
+}
+
+// Restore copia a quarentena de volta para o namespace original e confere a cópia. A quarentena não é
+// alterada. Retorna as entidades restauradas por kind.
+func Restore(ctx context.Context, client *datastore.Client, quarantined Namespace, tracker *progress.Tracker) (map[string]int64, error) {
+	slog.Info("Restaurando namespace da quarentena", "namespace", quarantined.Original, "quarantine", quarantined.Name)
+	restored, err := clone_data.CopyNamespace(ctx, client, client, quarantined.Name, quarantined.Original, tracker)
+	if err != nil {
+		return restored, err
+	}
+	if err := checkCopy(ctx, client, quarantined.Name, quarantined.Original); err != nil {
+		return restored, err
+	}
+	slog.Info("Namespace restaurado da quarentena", "namespace", quarantined.Original, "quarantine", quarantined.Name)
+	return restored, nil
+}
+
+// checkCopy confere, com contagens keys-only, se cada kind de `source` existe em `dest` com pelo menos
+// a mesma quantidade de registros.
+func checkCopy(ctx context.Context, client *datastore.Client, source, dest string) error {
+	_, sourceCounts, err := delete_data.CountRemaining(ctx, client, source)
+	if err != nil {
+		return err
+	}
+	_, destCounts, err := delete_data.CountRemaining(ctx, client, dest)
+	if err != nil {
+		return err
+	}
+	for kind, count := range sourceCounts {
+		if destCounts[kind] < count {
+			return fmt.Errorf("cópia incompleta do kind %s de %s para %s: %d de %d registros", kind, source, dest, destCounts[kind], count)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,401 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// Kind é o kind da fila de namespaces, gravado no namespace padrão do projeto.
+const Kind = "DestructorQueue"
+
+// Situações de um item da fila.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+var (
+	// LeaseTTL é a validade da reserva de um item sem heartbeat. Um item reservado por um worker que
+	// parou de responder volta a ficar disponível depois desse tempo.
+	LeaseTTL = 5 * time.Minute
+
+	// HeartbeatInterval é o intervalo de renovação da reserva enquanto o worker processa o item.
+	HeartbeatInterval = time.Minute
+
+	// MaxAttempts é a quantidade de tentativas de um item antes de ele deixar de ser tentado automaticamente.
+	MaxAttempts = 3
+
+	// RetryDelay é a espera antes de tentar novamente um item que falhou, multiplicada pelas tentativas.
+	RetryDelay = 5 * time.Minute
+)
+
+// Item é um namespace na fila.
+type Item struct {
+	Namespace      string
+	Status         string
+	Attempts       int
+	Worker         string
+	EnqueuedAt     time.Time
+	UpdatedAt      time.Time
+	LeaseExpiresAt time.Time
+	NextAttemptAt  time.Time
+	Kinds          int
+	Deleted        int64
+	Error          string `datastore:",noindex"`
+	PlanHash       string // plano aprovado que colocou o namespace na fila, se houver
+}
+
+// Retryable indica se o item falhou e ainda pode ser tentado novamente.
+func (i Item) Retryable() bool {
+	return i.Status == StatusFailed && i.Attempts < MaxAttempts
+}
+
+// claimable indica se um worker pode reservar o item agora.
+func (i Item) claimable(now time.Time) bool {
+	switch i.Status {
+	case StatusPending:
+		return true
+	case StatusRunning:
+		return now.After(i.LeaseExpiresAt)
+	case StatusFailed:
+		return i.Retryable() && !now.Before(i.NextAttemptAt)
+	}
+	return false
+}
+
+// itemKey retorna a chave do item. O prefixo evita nomes vazios e nomes reservados do Datastore (__x__).
+func itemKey(namespace string) *datastore.Key {
+	return datastore.NameKey(Kind, "namespace:"+namespace, nil)
+}
+
+// Enqueue adiciona os namespaces à fila. Namespaces que já estão na fila, em qualquer situação,
+// são mantidos como estão. `planHash` identifica o plano aprovado de origem e pode ser vazio.
+// Retorna a quantidade de namespaces adicionados.
+func Enqueue(ctx context.Context, client *datastore.Client, namespaces []string, planHash string) (int, error) {
+	const batchSize = 500
+	added := 0
+	seen := make(map[string]bool)
+	var unique []string
+	for _, namespace := range namespaces {
+		if !seen[namespace] {
+			seen[namespace] = true
+			unique = append(unique, namespace)
+		}
+	}
+
+	for start := 0; start < len(unique); start += batchSize {
+		batch := unique[start:min(start+batchSize, len(unique))]
+		keys := make([]*datastore.Key, len(batch))
+		for i, namespace := range batch {
+			keys[i] = itemKey(namespace)
+		}
+
+		existing := make([]Item, len(keys))
+		err := client.GetMulti(ctx, keys, existing)
+		multiErr, isMultiErr := err.(datastore.MultiError)
+		if err != nil && !isMultiErr {
+			return added, fmt.Errorf("falha ao consultar a fila: %v", err)
+		}
+
+		now := time.Now().UTC()
+		var newKeys []*datastore.Key
+		var newItems []Item
+		for i, key := range keys {
+			if !isMultiErr || multiErr[i] == nil {
+				continue // já está na fila
+			}
+			if !errors.Is(multiErr[i], datastore.ErrNoSuchEntity) {
+				return added, fmt.Errorf("falha ao consultar o namespace %s na fila: %v", batch[i], multiErr[i])
+			}
+			newKeys = append(newKeys, key)
+			newItems = append(newItems, Item{Namespace: batch[i], Status: StatusPending, EnqueuedAt: now, UpdatedAt: now, PlanHash: planHash})
+		}
+		if len(newKeys) == 0 {
+			continue
+		}
+		if _, err := client.PutMulti(ctx, newKeys, newItems); err != nil {
+			return added, fmt.Errorf("falha ao adicionar namespaces à fila: %v", err)
+		}
+		added += len(newKeys)
+	}
+	return added, nil
+}
+
+// Claim reserva o próximo item disponível para o worker: pendentes primeiro, depois os que falharam
+// e podem ser tentados novamente e os reservados por workers que pararam de responder. Retorna nil
+// quando não há item disponível.
+func Claim(ctx context.Context, client *datastore.Client, worker string) (*Item, error) {
+	for _, status := range []string{StatusPending, StatusFailed, StatusRunning} {
+		query := datastore.NewQuery(Kind).FilterField("Status", "=", status).KeysOnly()
+		if status == StatusPending {
+			// Busca alguns pendentes por vez, pois outros workers podem reservá-los primeiro. Os que
+			// falharam ou estão reservados são poucos e precisam ser todos avaliados
+			query = query.Limit(20)
+		}
+		keys, err := client.GetAll(ctx, query, nil)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao consultar a fila: %v", err)
+		}
+
+		for _, key := range keys {
+			item, err := claimKey(ctx, client, key, worker)
+			if err != nil {
+				return nil, err
+			}
+			if item != nil {
+				return item, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// claimKey reserva o item em uma transação, retornando nil se ele não estiver mais disponível.
+func claimKey(ctx context.Context, client *datastore.Client, key *datastore.Key, worker string) (*Item, error) {
+	var claimed *Item
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		claimed = nil
+		var item Item
+		if err := tx.Get(key, &item); err != nil {
+			if errors.Is(err, datastore.ErrNoSuchEntity) {
+				return nil
+			}
+			return err
+		}
+		now := time.Now().UTC()
+		if !item.claimable(now) {
+			return nil
+		}
+
+		item.Status = StatusRunning
+		item.Worker = worker
+		item.Attempts++
+		item.UpdatedAt = now
+		item.LeaseExpiresAt = now.Add(LeaseTTL)
+		if _, err := tx.Put(key, &item); err != nil {
+			return err
+		}
+		claimed = &item
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("falha ao reservar o item %s da fila: %v", key.Name, err)
+	}
+	return claimed, nil
+}
+
+// NotClaimedError indica que o item não está mais reservado pelo worker, ex: a reserva expirou e outro
+// worker o reservou.
+type NotClaimedError struct {
+	Status string
+	Worker string
+}
+
+func (e *NotClaimedError) Error() string {
+	return fmt.Sprintf("o item não está mais reservado por este worker (%s, %s)", e.Status, e.Worker)
+}
+
+// update altera o item em uma transação, desde que ele ainda esteja reservado pelo worker. Se não
+// estiver, retorna um *NotClaimedError.
+func update(ctx context.Context, client *datastore.Client, namespace, worker string, change func(item *Item)) error {
+	key := itemKey(namespace)
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var item Item
+		if err := tx.Get(key, &item); err != nil {
+			return err
+		}
+		if item.Status != StatusRunning || item.Worker != worker {
+			return &NotClaimedError{Status: item.Status, Worker: item.Worker}
+		}
+		change(&item)
+		item.UpdatedAt = time.Now().UTC()
+		_, err := tx.Put(key, &item)
+		return err
+	})
+	if err != nil {
+		var notClaimed *NotClaimedError
+		if errors.As(err, &notClaimed) {
+			return notClaimed
+		}
+		return fmt.Errorf("falha ao atualizar o namespace %s na fila: %v", namespace, err)
+	}
+	return nil
+}
+
+// Complete marca o item como concluído com a quantidade de registros deletados, ou como falho com o
+// erro. Um item falho é tentado novamente depois de RetryDelay multiplicado pelas tentativas.
+func Complete(ctx context.Context, client *datastore.Client, item *Item, worker string, deleted map[string]int64, processErr error) error {
+	return update(ctx, client, item.Namespace, worker, func(current *Item) {
+		current.Kinds = len(deleted)
+		current.Deleted = 0
+		for _, count := range deleted {
+			current.Deleted += count
+		}
+		if processErr != nil {
+			current.Status = StatusFailed
+			current.Error = processErr.Error()
+			current.NextAttemptAt = time.Now().UTC().Add(RetryDelay * time.Duration(current.Attempts))
+			return
+		}
+		current.Status = StatusDone
+		current.Error = ""
+	})
+}
+
+// Handler processa o namespace de um item reservado e retorna a quantidade de registros deletados por kind.
+type Handler func(ctx context.Context, item *Item) (map[string]int64, error)
+
+// RunWorker reserva e processa itens da fila até o contexto ser cancelado, ou até a fila ficar
+// vazia quando `exitWhenEmpty` for verdadeiro. A reserva é renovada enquanto o item é processado.
+func RunWorker(ctx context.Context, client *datastore.Client, worker string, exitWhenEmpty bool, handler Handler) error {
+	const idleWait = 30 * time.Second
+	for ctx.Err() == nil {
+		item, err := Claim(ctx, client, worker)
+		if err != nil {
+			slog.Error("Erro ao reservar um item da fila", "error", err)
+			wait(ctx, idleWait)
+			continue
+		}
+		if item == nil {
+			if exitWhenEmpty {
+				slog.Info("Fila vazia, encerrando o worker")
+				return nil
+			}
+			slog.Debug("Fila vazia, aguardando novos itens")
+			wait(ctx, idleWait)
+			continue
+		}
+
+		slog.Info("Item da fila reservado", "namespace", item.Namespace, "attempt", item.Attempts, "worker", worker)
+		itemCtx, stopHeartbeat := context.WithCancel(ctx)
+		heartbeatDone := make(chan struct{})
+		go func() {
+			defer close(heartbeatDone)
+			heartbeat(itemCtx, stopHeartbeat, client, item.Namespace, worker)
+		}()
+
+		deleted, processErr := handler(itemCtx, item)
+		stopHeartbeat()
+		<-heartbeatDone
+
+		if err := Complete(context.Background(), client, item, worker, deleted, processErr); err != nil {
+			slog.Error("Erro ao concluir o item da fila", "namespace", item.Namespace, "error", err)
+			continue
+		}
+		if processErr != nil {
+			slog.Error("Falha ao processar o item da fila", "namespace", item.Namespace, "attempt", item.Attempts, "error", processErr)
+		} else {
+			slog.Info("Item da fila concluído", "namespace", item.Namespace)
+		}
+	}
+	return ctx.Err()
+}
+
+// wait aguarda `d` ou até o contexto ser cancelado.
+func wait(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// heartbeat renova a reserva do item até o contexto ser cancelado. Se o item deixar de ser do worker,
+// ou se a reserva expirar sem ser renovada, chama `cancel` para interromper o processamento, pois outro
+// worker pode ter reservado o item.
+func heartbeat(ctx context.Context, cancel context.CancelFunc, client *datastore.Client, namespace, worker string) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	renewedAt := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := update(ctx, client, namespace, worker, func(item *Item) {
+			item.LeaseExpiresAt = time.Now().UTC().Add(LeaseTTL)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		var notClaimed *NotClaimedError
+		switch {
+		case errors.As(err, &notClaimed):
+			slog.Error("Reserva do item da fila perdida, interrompendo o processamento", "namespace", namespace, "status", notClaimed.Status, "worker", notClaimed.Worker)
+			cancel()
+			return
+		case err != nil && time.Since(renewedAt) >= LeaseTTL:
+			slog.Error("Reserva do item da fila expirou sem ser renovada, interrompendo o processamento", "namespace", namespace, "error", err)
+			cancel()
+			return
+		case err != nil:
+			// A reserva continua válida até expirar, então a falha é tentada novamente no próximo ciclo
+			slog.Warn("Falha ao renovar a reserva do item da fila", "namespace", namespace, "error", err)
+		default:
+			renewedAt = time.Now()
+		}
+	}
+}
+
+// List retorna todos os itens da fila ordenados pelo namespace.
+func List(ctx context.Context, client *datastore.Client) ([]Item, error) {
+	var items []Item
+	it := client.Run(ctx, datastore.NewQuery(Kind))
+	for {
+		var item Item
+		_, err := it.Next(&item)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("falha ao listar a fila: %v", err)
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Namespace < items[j].Namespace
+	})
+	return items, nil
+}
+
+// Retry devolve para a fila os itens que falharam, inclusive os que esgotaram as tentativas.
+// Retorna a quantidade de itens devolvidos.
+func Retry(ctx context.Context, client *datastore.Client) (int, error) {
+	keys, err := client.GetAll(ctx, datastore.NewQuery(Kind).FilterField("Status", "=", StatusFailed).KeysOnly(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("falha ao consultar a fila: %v", err)
+	}
+
+	retried := 0
+	for _, key := range keys {
+		_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			var item Item
+			if err := tx.Get(key, &item); err != nil {
+				return err
+			}
+			if item.Status != StatusFailed {
+				return nil
+			}
+			item.Status = StatusPending
+			item.Attempts = 0
+			item.NextAttemptAt = time.Time{}
+			item.UpdatedAt = time.Now().UTC()
+			_, err := tx.Put(key, &item)
+			return err
+		})
+		if err != nil {
+			return retried, fmt.Errorf("falha ao devolver o item %s para a fila: %v", key.Name, err)
+		}
+		retried++
+	}
+	return retried, nil
+}
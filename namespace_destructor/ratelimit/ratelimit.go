@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"namespace_destructor/metrics"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	minFactor     = 0.05             // a taxa nunca cai abaixo de 5% da configurada
+	backoffEvery  = time.Second      // intervalo mínimo entre duas reduções, evita reagir várias vezes ao mesmo pico
+	recoverEvery  = 10 * time.Second // intervalo entre os aumentos graduais após uma redução
+	recoverFactor = 1.25
+)
+
+// Default é o limitador compartilhado pelas deleções, clonagens e varreduras. Nil significa sem limite.
+var Default *Limiter
+
+// Limiter limita as operações em entidades por segundo com um token bucket. Quando o Datastore
+// responde com ResourceExhausted ou Aborted, a taxa é reduzida pela metade e depois volta
+// gradualmente à taxa configurada. Todos os métodos aceitam um Limiter nil, que não limita nada.
+type Limiter struct {
+	limiter *rate.Limiter
+	base    float64
+
+	mu         sync.Mutex
+	factor     float64
+	lastChange time.Time
+}
+
+// New cria um limitador de `opsPerSecond` operações por segundo. Com zero, retorna nil (sem limite).
+func New(opsPerSecond float64) *Limiter {
+	if opsPerSecond <= 0 {
+		return nil
+	}
+	burst := max(1, int(opsPerSecond))
+	metrics.RateLimit.Set(opsPerSecond)
+	return &Limiter{limiter: rate.NewLimiter(rate.Limit(opsPerSecond), burst), base: opsPerSecond, factor: 1}
+}
+
+// Wait bloqueia até que `n` operações possam ser executadas ou o contexto seja cancelado.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	// O WaitN não aceita mais operações que o burst de uma vez, então os lotes grandes são divididos
+	for n > 0 {
+		chunk := min(n, l.limiter.Burst())
+		if err := l.limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// Observe ajusta a taxa de acordo com o resultado de uma chamada ao Datastore: reduz após erros de
+// sobrecarga e recupera gradualmente após sucessos.
+func (l *Limiter) Observe(err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	overloaded := IsOverloaded(err)
+	since := time.Since(l.lastChange)
+	switch {
+	case overloaded && since >= backoffEvery && l.factor > minFactor:
+		l.factor = max(minFactor, l.factor/2)
+		slog.Warn("Datastore sobrecarregado, reduzindo a taxa de operações", "rate", l.current(), "error", err)
+	case !overloaded && err == nil && l.factor < 1 && since >= recoverEvery:
+		l.factor = min(1, l.factor*recoverFactor)
+		slog.Info("Aumentando a taxa de operações", "rate", l.current())
+	default:
+		return
+	}
+	l.lastChange = time.Now()
+	l.limiter.SetLimit(rate.Limit(l.current()))
+	metrics.RateLimit.Set(l.current())
+}
+
+func (l *Limiter) current() float64 {
+	return l.base * l.factor
+}
+
+// IsOverloaded indica se o erro do Datastore é de sobrecarga ou contenção (ResourceExhausted ou
+// Aborted), inclusive dentro de um MultiError.
+func IsOverloaded(err error) bool {
+	if err == nil {
+		return false
+	}
+	var multiErr datastore.MultiError
+	if errors.As(err, &multiErr) {
+		for _, item := range multiErr {
+			if IsOverloaded(item) {
+				return true
+			}
+		}
+		return false
+	}
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// IsTransient indica se o erro do Datastore é temporário e a chamada pode ser repetida: sobrecarga,
+// indisponibilidade ou timeout, inclusive dentro de um MultiError.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var multiErr datastore.MultiError
+	if errors.As(err, &multiErr) {
+		for _, item := range multiErr {
+			if IsTransient(item) {
+				return true
+			}
+		}
+		return false
+	}
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Aborted, codes.Unavailable, codes.DeadlineExceeded, codes.Internal:
+		return true
+	}
+	return false
+}
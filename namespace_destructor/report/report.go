@@ -0,0 +1,91 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"namespace_destructor/namespace_list"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Namespace é o resultado da deleção de um namespace na execução.
+type Namespace struct {
+	Namespace     string           `json:"namespace"`
+	PlanHash      string           `json:"planHash,omitempty"`
+	Quarantine    string           `json:"quarantine,omitempty"` // cópia feita antes da deleção
+	Deleted       map[string]int64 `json:"deleted"`              // registros deletados por kind
+	Remaining     map[string]int64 `json:"remaining,omitempty"`  // registros encontrados na última verificação
+	VerifyPasses  int              `json:"verifyPasses"`
+	VerifiedEmpty bool             `json:"verifiedEmpty"` // __kind__ não retornou nenhum kind depois da deleção
+	Error         string           `json:"error,omitempty"`
+	StartedAt     time.Time        `json:"startedAt"`
+	FinishedAt    time.Time        `json:"finishedAt"`
+}
+
+// Report é o relatório de uma execução, regravado a cada namespace concluído para que uma execução
+// interrompida deixe o relatório do que já foi feito.
+type Report struct {
+	Path      string
+	RunID     string
+	Project   string
+	StartedAt time.Time
+
+	mu         sync.Mutex
+	namespaces map[string]Namespace
+}
+
+// New cria o relatório da execução em `<dir>/run_<runID>.json`. O arquivo só é criado quando o primeiro
+// namespace é registrado.
+func New(dir, runID, project string) *Report {
+	return &Report{
+		Path:       filepath.Join(dir, "run_"+runID+".json"),
+		RunID:      runID,
+		Project:    project,
+		StartedAt:  time.Now().UTC(),
+		namespaces: make(map[string]Namespace),
+	}
+}
+
+// Record registra o resultado do namespace, substituindo um registro anterior do mesmo namespace, e
+// grava o relatório. Um relatório nil não registra nada.
+func (r *Report) Record(result Namespace) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.namespaces[result.Namespace] = result
+
+	names := make([]string, 0, len(r.namespaces))
+	verified := 0
+	for name, item := range r.namespaces {
+		names = append(names, name)
+		if item.VerifiedEmpty {
+			verified++
+		}
+	}
+	sort.Strings(names)
+	namespaces := make([]Namespace, len(names))
+	for i, name := range names {
+		namespaces[i] = r.namespaces[name]
+	}
+
+	data, err := json.MarshalIndent(struct {
+		RunID         string      `json:"runId"`
+		Project       string      `json:"project"`
+		StartedAt     time.Time   `json:"startedAt"`
+		UpdatedAt     time.Time   `json:"updatedAt"`
+		VerifiedEmpty int         `json:"verifiedEmpty"`
+		Namespaces    []Namespace `json:"namespaces"`
+	}{r.RunID, r.Project, r.StartedAt, time.Now().UTC(), verified, namespaces}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("falha ao codificar o relatório: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		return fmt.Errorf("falha ao criar o diretório do relatório %s: %v", r.Path, err)
+	}
+	return namespace_list.WriteAtomic(r.Path, append(data, '\n'))
+}
@@ -0,0 +1,51 @@
+package scheduler
+
+import (
+	"context"
+	"namespace_destructor/metrics"
+	"namespace_destructor/progress"
+	"sync"
+	"sync/atomic"
+)
+
+// Run executa `fn` para cada namespace, com até `concurrency` namespaces ao mesmo tempo, para que
+// namespaces pequenos não esperem atrás de um namespace grande. O limite de kinds e de operações
+// simultâneas é global (delete_data.SetMaxKinds e ratelimit.Default), então vale para todos os
+// namespaces em processamento. O andamento é informado ao `tracker` e à métrica de namespaces pendentes.
+// Com o contexto cancelado, nenhum namespace novo é iniciado.
+func Run(ctx context.Context, namespaces []string, concurrency int, tracker *progress.Tracker, fn func(ctx context.Context, namespace string)) {
+	total := len(namespaces)
+	var finished atomic.Int64
+	tracker.SetQueue(0, total)
+	metrics.PendingNamespaces.Set(float64(total))
+	defer func() {
+		tracker.SetQueue(0, 0)
+		metrics.PendingNamespaces.Set(0)
+	}()
+
+	namespaceCh := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < max(1, concurrency); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for namespace := range namespaceCh {
+				fn(ctx, namespace)
+				done := int(finished.Add(1))
+				tracker.SetQueue(done, total)
+				metrics.PendingNamespaces.Set(float64(total - done))
+			}
+		}()
+	}
+
+dispatch:
+	for _, namespace := range namespaces {
+		select {
+		case namespaceCh <- namespace:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(namespaceCh)
+	wg.Wait()
+}
@@ -0,0 +1,64 @@
+package worklist
+
+import (
+	"fmt"
+	"namespace_destructor/quarantine"
+	"regexp"
+	"strings"
+)
+
+// validNamespace são os caracteres e o tamanho aceitos pelo Datastore em nomes de namespaces.
+var validNamespace = regexp.MustCompile(`^[0-9A-Za-z._-]{1,100}$`)
+
+// Rejected é um namespace da origem que ficou fora da lista de trabalho.
+type Rejected struct {
+	Namespace string
+	Reason    string
+	Detail    string
+}
+
+// Validate verifica se o nome pode ser um namespace de dados do Datastore. O namespace padrão (vazio),
+// os reservados (`__x__`) e os de quarentena nunca são aceitos.
+func Validate(namespace string) error {
+	switch {
+	case namespace == "":
+		return fmt.Errorf("o namespace padrão não pode ser destruído")
+	case strings.HasPrefix(namespace, "__") && strings.HasSuffix(namespace, "__"):
+		return fmt.Errorf("namespace reservado do Datastore")
+	case quarantine.IsQuarantine(namespace):
+		return fmt.Errorf("namespace de quarentena, removido apenas pelo purge-quarantine")
+	case !validNamespace.MatchString(namespace):
+		return fmt.Errorf("use até 100 letras, números, ponto, hífen ou underline")
+	}
+	return nil
+}
+
+// Filter remove os namespaces repetidos e separa os inválidos e os que estão na lista de seguros,
+// mantendo a ordem da origem.
+func Filter(namespaces, safeNamespaces []string) ([]string, []Rejected) {
+	safe := make(map[string]bool, len(safeNamespaces))
+	for _, namespace := range safeNamespaces {
+		safe[namespace] = true
+	}
+
+	var accepted []string
+	var rejected []Rejected
+	seen := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		if seen[namespace] {
+			continue
+		}
+		seen[namespace] = true
+
+		if err := Validate(namespace); err != nil {
+			rejected = append(rejected, Rejected{Namespace: namespace, Reason: RejectInvalid, Detail: err.Error()})
+			continue
+		}
+		if safe[namespace] {
+			rejected = append(rejected, Rejected{Namespace: namespace, Reason: RejectSafe})
+			continue
+		}
+		accepted = append(accepted, namespace)
+	}
+	return accepted, rejected
+}
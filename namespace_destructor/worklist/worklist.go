@@ -0,0 +1,285 @@
+package worklist
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"namespace_destructor/api"
+	"namespace_destructor/config"
+	"namespace_destructor/get_data"
+	"namespace_destructor/namespace_list"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Tipos de origem da lista de namespaces.
+const (
+	SourceFile      = "file"      // um namespace por linha, ex: namespaces.txt
+	SourceStdin     = "stdin"     // um namespace por linha, lido da entrada padrão
+	SourceCSV       = "csv"       // uma coluna de um arquivo CSV
+	SourceQuery     = "query"     // um filtro salvo em namespaceFilters, consultado em __namespace__
+	SourceCancelled = "cancelled" // namespaces dos assinantes cancelados segundo a API do CS
+)
+
+// Motivos para um namespace da origem ficar fora da lista de trabalho.
+const (
+	RejectInvalid = "invalid" // não é um nome de namespace válido no Datastore
+	RejectSafe    = "safe"    // está na lista de namespaces seguros
+)
+
+// Source é a origem da lista de namespaces.
+type Source struct {
+	Type   string
+	Path   string // arquivo das origens file e csv
+	Column string // coluna do csv: o nome no cabeçalho ou a posição a partir de 1 (sem cabeçalho)
+	Filter string // nome do filtro da origem query
+	Days   int    // origem cancelled: dias mínimos desde o fim do período contratado
+
+	spec string
+}
+
+// Parse interpreta a origem: um caminho de arquivo (ou `file:<arquivo>`), `-` ou `stdin`,
+// `csv:<arquivo>:<coluna>`, `query:<filtro>` ou `cancelled[:<dias>]`.
+func Parse(spec string) (Source, error) {
+	source := Source{spec: spec}
+	kind, value, _ := strings.Cut(spec, ":")
+	switch {
+	case spec == "":
+		return Source{}, fmt.Errorf("origem dos namespaces não informada")
+	case spec == "-" || spec == SourceStdin:
+		source.Type = SourceStdin
+	case kind == SourceCSV:
+		i := strings.LastIndex(value, ":")
+		if i <= 0 || i == len(value)-1 {
+			return Source{}, fmt.Errorf("origem %q inválida, use csv:<arquivo>:<coluna>", spec)
+		}
+		source.Type, source.Path, source.Column = SourceCSV, value[:i], value[i+1:]
+	case kind == SourceQuery:
+		if value == "" {
+			return Source{}, fmt.Errorf("origem %q inválida, use query:<filtro>", spec)
+		}
+		source.Type, source.Filter = SourceQuery, value
+	case kind == SourceCancelled:
+		source.Type = SourceCancelled
+		if value != "" {
+			days, err := strconv.Atoi(value)
+			if err != nil || days < 0 {
+				return Source{}, fmt.Errorf("origem %q inválida, use cancelled:<dias>", spec)
+			}
+			source.Days = days
+		}
+	case kind == SourceFile && value != "":
+		source.Type, source.Path = SourceFile, value
+	default:
+		source.Type, source.Path = SourceFile, spec
+	}
+	return source, nil
+}
+
+func (s Source) String() string {
+	return s.spec
+}
+
+// File retorna o arquivo lido pela origem, ou vazio se a origem não é um arquivo.
+func (s Source) File() string {
+	if s.Type == SourceFile || s.Type == SourceCSV {
+		return s.Path
+	}
+	return ""
+}
+
+// StatusName é o nome da lista usado no arquivo de andamento (namespace_list.MarkStatus): o próprio
+// arquivo da origem ou, nas demais origens, um nome que a identifica no diretório atual.
+func (s Source) StatusName() string {
+	if file := s.File(); file != "" {
+		return file
+	}
+	if s.Type == SourceQuery {
+		return "worklist-query-" + s.Filter
+	}
+	return "worklist-" + s.Type
+}
+
+// Loader lê a lista de namespaces de qualquer origem.
+type Loader struct {
+	Datastore *datastore.Client                 // usado pela origem query
+	Filters   map[string]config.NamespaceFilter // filtros salvos da origem query
+	CS        config.CSConfig                   // API de assinantes da origem cancelled
+	Stdin     io.Reader                         // entrada da origem stdin (padrão os.Stdin)
+}
+
+// Worklist lê os namespaces da origem e aplica as mesmas regras a todas as origens: namespaces repetidos
+// aparecem uma única vez, e os inválidos e os da lista de seguros são retornados em `rejected`.
+func (l *Loader) Worklist(ctx context.Context, source Source, safeNamespaces []string) (namespaces []string, rejected []Rejected, err error) {
+	loaded, err := l.Load(ctx, source)
+	if err != nil {
+		return nil, nil, err
+	}
+	namespaces, rejected = Filter(loaded, safeNamespaces)
+	for _, item := range rejected {
+		if item.Reason == RejectInvalid {
+			slog.Warn("Namespace inválido ignorado", "namespace", item.Namespace, "source", source.String(), "error", item.Detail)
+		}
+	}
+	slog.Info("Lista de namespaces carregada", "source", source.String(), "namespaces", len(namespaces), "rejected", len(rejected))
+	return namespaces, rejected, nil
+}
+
+// Load lê os namespaces da origem, sem validação.
+func (l *Loader) Load(ctx context.Context, source Source) ([]string, error) {
+	switch source.Type {
+	case SourceFile:
+		return namespace_list.Read(source.Path)
+	case SourceStdin:
+		stdin := l.Stdin
+		if stdin == nil {
+			stdin = os.Stdin
+		}
+		namespaces, err := namespace_list.Parse(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler os namespaces da entrada padrão: %v", err)
+		}
+		return namespaces, nil
+	case SourceCSV:
+		return loadCSV(source.Path, source.Column)
+	case SourceQuery:
+		return l.loadQuery(ctx, source.Filter)
+	case SourceCancelled:
+		return l.loadCancelled(ctx, source.Days)
+	}
+	return nil, fmt.Errorf("origem %q desconhecida", source.Type)
+}
+
+// loadCSV lê uma coluna do CSV. Uma coluna informada pelo nome é procurada na primeira linha
+// (cabeçalho); informada pela posição, todas as linhas são lidas.
+func loadCSV(filename, column string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao abrir o arquivo %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	index := -1
+	if position, err := strconv.Atoi(column); err == nil {
+		if position < 1 {
+			return nil, fmt.Errorf("coluna %d inválida no arquivo %s, as posições começam em 1", position, filename)
+		}
+		index = position - 1
+	} else {
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler o cabeçalho do arquivo %s: %v", filename, err)
+		}
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), column) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("coluna %s não encontrada no cabeçalho do arquivo %s", column, filename)
+		}
+	}
+
+	var namespaces []string
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler o arquivo %s: %v", filename, err)
+		}
+		if index < len(record) {
+			namespaces = append(namespaces, strings.TrimSpace(record[index]))
+		}
+	}
+	return namespaces, nil
+}
+
+// loadQuery consulta __namespace__ com um filtro salvo.
+func (l *Loader) loadQuery(ctx context.Context, name string) ([]string, error) {
+	filter, ok := l.Filters[name]
+	if !ok {
+		return nil, fmt.Errorf("filtro %s não encontrado em namespaceFilters", name)
+	}
+	// Um filtro sem critérios selecionaria todos os namespaces do projeto para a deleção
+	if filter.Empty() {
+		return nil, fmt.Errorf("o filtro %s não tem nenhum critério (prefix, contains ou pattern)", name)
+	}
+	if l.Datastore == nil {
+		return nil, fmt.Errorf("a origem query precisa do cliente do Datastore")
+	}
+	// As expressões já foram validadas junto com a configuração
+	pattern := regexp.MustCompile(filter.Pattern)
+	var exclude *regexp.Regexp
+	if filter.Exclude != "" {
+		exclude = regexp.MustCompile(filter.Exclude)
+	}
+
+	all, err := get_data.ListNamespacesWithPrefix(ctx, l.Datastore, filter.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	var namespaces []string
+	for _, namespace := range all {
+		if !strings.Contains(namespace, filter.Contains) || !pattern.MatchString(namespace) {
+			continue
+		}
+		if exclude != nil && exclude.MatchString(namespace) {
+			continue
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	slog.Info("Filtro de namespaces aplicado", "filter", name, "listed", len(all), "matched", len(namespaces))
+	return namespaces, nil
+}
+
+// loadCancelled lista os namespaces dos assinantes cancelados: acesso inativo e período contratado
+// encerrado há pelo menos `days` dias. Assinantes com situação duvidosa não entram na lista.
+func (l *Loader) loadCancelled(ctx context.Context, days int) ([]string, error) {
+	if l.CS.APIKey == "" {
+		return nil, fmt.Errorf("API_KEY_CS não configurada (defina no .env, no ambiente ou em cs.apiKey)")
+	}
+	client, err := api.NewSubscriberClient(api.SubscriberConfig{BaseURL: l.CS.BaseURL, APIKey: l.CS.APIKey, Timeout: l.CS.Timeout, Concurrency: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	subscribers, err := client.ListSubscribers(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+
+	var namespaces []string
+	cancelled := 0
+	for _, subscriber := range subscribers {
+		status := api.SubscriberGetSubscriberStatus{AccessActive: subscriber.AccessActive, CSPeriod: subscriber.CSPeriod}
+		if !status.IsChurnedSince(cutoff) {
+			continue
+		}
+		cancelled++
+		linked, err := client.GetSubscriberNamespaces(ctx, subscriber.SubscriberUId)
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range linked {
+			namespaces = append(namespaces, link.Namespace)
+		}
+	}
+	slog.Info("Assinantes cancelados listados", "inactive", len(subscribers), "cancelled", cancelled, "days", days, "namespaces", len(namespaces))
+	return namespaces, nil
+}